@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"rutube-downloader/internal/handler"
 )
@@ -19,6 +24,11 @@ func main() {
 		log.Println("📁 Авто-переход в директорию:", projectRoot)
 	}
 
+	// — Задачи: поднимаем сохранённые до перезапуска —
+	if err := handler.InitJobStore(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
 	// — Роутинг —
 	http.HandleFunc("/", handler.IndexHandler)
 	http.HandleFunc("/download", handler.DownloadHandler)
@@ -40,18 +50,46 @@ func main() {
 	http.HandleFunc("/top-rutube-videos", handler.TopRutubeHandler)
 	http.HandleFunc("/rutube-ads-remove", handler.RutubeAdsRemoveHandler)
 	http.HandleFunc("/progress", handler.ProgressHandler)
+	http.HandleFunc("/api/info", handler.InfoHandler)
+	http.HandleFunc("DELETE /api/jobs/{id}", handler.CancelJobHandler)
+	http.HandleFunc("POST /api/jobs/{id}/stop", handler.StopJobHandler)
 
 	// — Статика —
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.Handle("/downloads/", http.StripPrefix("/downloads/", http.FileServer(http.Dir("downloads"))))
+	http.Handle("/downloads/", handler.DownloadsHandler("downloads"))
 	http.Handle("/sitemap.xml", http.FileServer(http.Dir("static")))
 	http.Handle("/robots.txt", http.FileServer(http.Dir("static")))
 
 	// — Слушаем только localhost:8080 —
 	addr := "127.0.0.1:8080"
-	log.Println("🚀 Backend running on", addr, "(за nginx-прокси)")
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("❌ ListenAndServe error: %v", err)
+	srv := &http.Server{Addr: addr}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// — Уборка истёкших задач и файлов —
+	handler.StartJanitor(ctx)
+
+	go func() {
+		log.Println("🚀 Backend running on", addr, "(за nginx-прокси)")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("❌ ListenAndServe error: %v", err)
+		}
+	}()
+
+	// — Корректная остановка: обрываем загрузки и ffmpeg, дожидаемся текущих запросов —
+	<-ctx.Done()
+	log.Println("🛑 Останавливаемся…")
+	handler.CancelJobs()
+	// ffmpeg убивается из отдельной горутины — дожидаемся, пока задачи его похоронят
+	if !handler.WaitJobs(10 * time.Second) {
+		log.Println("⚠️ Не все загрузки успели остановиться")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ Shutdown error: %v", err)
 	}
 }
 
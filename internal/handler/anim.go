@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"rutube-downloader/internal/parser"
+)
+
+// animFromForm читает GIF / зацикленное видео: anim_width, anim_fps и фрагмент
+// start + duration (секунды, мм:сс или чч:мм:сс). Остальные проверки — в parser.Options.Validate.
+func animFromForm(r *http.Request, f parser.AnimFormat) (parser.AnimOptions, parser.Clip, error) {
+	a, err := parser.ParseAnimOptions(f, r.FormValue("anim_width"), r.FormValue("anim_fps"))
+	if err != nil {
+		return parser.AnimOptions{}, parser.Clip{}, err
+	}
+	start, err := parser.ParseTimestamp(r.FormValue("start"))
+	if err != nil {
+		return parser.AnimOptions{}, parser.Clip{}, errors.New("неверное начало фрагмента")
+	}
+	dur, err := parser.ParseTimestamp(r.FormValue("duration"))
+	if err != nil || dur <= 0 {
+		return parser.AnimOptions{}, parser.Clip{}, errors.New("укажите длительность фрагмента")
+	}
+	return a, parser.Clip{Start: start, End: start + dur}, nil
+}
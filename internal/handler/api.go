@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"rutube-downloader/internal/parser"
+)
+
+// InfoHandler — GET /api/info?url=... отдаёт метаданные ролика без скачивания
+func InfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	url := strings.TrimSpace(r.URL.Query().Get("url"))
+	if !strings.Contains(url, "rutube.ru") {
+		writeJSONError(w, http.StatusBadRequest, "Введите корректную ссылку на RuTube")
+		return
+	}
+
+	// ссылка не на ролик (плейлист, канал, опечатка) — ошибка запроса, а не RuTube
+	if _, err := parser.ParseVideoURL(url); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	info, err := parser.GetInfo(r.Context(), url)
+	if errors.Is(err, parser.ErrPrivateVideo) {
+		writeJSONError(w, http.StatusForbidden, privateVideoText)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка получения информации о видео: %v", err)
+		writeJSONError(w, http.StatusBadGateway, "Не удалось получить информацию о видео")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// CancelJobHandler — DELETE /api/jobs/{id}?token=... отменяет загрузку и удаляет недокачанное.
+// Отменить может только запросивший со своим токеном; если ту же задачу ждут и другие,
+// запрос только отвязывается от неё (status "detached"), а повтор с тем же токеном уже ничего не делает.
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	j, ok := getJob(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if j.finished() {
+		writeJSONError(w, http.StatusConflict, "задача уже завершена")
+		return
+	}
+	known, cancelled := releaseJob(id, r.URL.Query().Get("token"))
+	switch {
+	case cancelled:
+		writeJSON(w, http.StatusOK, map[string]string{"id": id, "status": string(JobCancelled)})
+	case known:
+		writeJSON(w, http.StatusOK, map[string]string{"id": id, "status": "detached"})
+	default:
+		writeJSONError(w, http.StatusForbidden, "отменить загрузку может только тот, кто её запустил")
+	}
+}
+
+// StopJobHandler — POST /api/jobs/{id}/stop?token=... заканчивает запись эфира и сохраняет записанное
+func StopJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	j, ok := getJob(id)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !slices.Contains(j.Watchers, r.URL.Query().Get("token")) {
+		writeJSONError(w, http.StatusForbidden, "остановить запись может только тот, кто её запустил")
+		return
+	}
+	if len(j.Watchers) > 1 {
+		// остановка укоротила бы запись всем, кто её ждёт
+		writeJSONError(w, http.StatusConflict, "эту запись ждут и другие — она закончится по лимиту или с концом эфира")
+		return
+	}
+	if _, stopping := stopJob(id); !stopping {
+		writeJSONError(w, http.StatusConflict, "задача не записывает эфир")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id, "status": string(JobRunning)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
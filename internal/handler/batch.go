@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rutube-downloader/internal/parser"
+)
+
+// Виды родительских задач
+const (
+	KindPlaylist = "playlist"
+	KindChannel  = "channel"
+)
+
+// batchPoll — как часто родитель собирает прогресс дочерних задач
+const batchPoll = time.Second
+
+// batchResolver — список роликов набора и его заголовок
+type batchResolver func(ctx context.Context) (title string, videos []parser.ListedVideo, err error)
+
+// resolverFor — как получить ролики для родительской задачи по её сохранённым полям
+func resolverFor(j Job) (batchResolver, error) {
+	switch j.Kind {
+	case KindPlaylist:
+		id, err := parser.ParsePlaylistURL(j.URL)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (string, []parser.ListedVideo, error) {
+			pl, err := parser.GetPlaylist(ctx, id)
+			if err != nil {
+				return "", nil, err
+			}
+			return pl.Title, pl.Videos, nil
+		}, nil
+	case KindChannel:
+		id, err := parser.ParseChannelURL(j.URL)
+		if err != nil {
+			return nil, err
+		}
+		var f parser.ChannelFilter
+		if j.Filter != nil {
+			f = *j.Filter
+		}
+		return func(ctx context.Context) (string, []parser.ListedVideo, error) {
+			ch, err := parser.GetChannel(ctx, id, f)
+			if err != nil {
+				return "", nil, err
+			}
+			return ch.Title, ch.Videos, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("неизвестный вид задачи %q", j.Kind)
+}
+
+// startBatch сохраняет родительскую задачу и запускает её координатор.
+// Сам родитель место в очереди не занимает — качают дочерние задачи.
+func startBatch(j *Job, quality parser.Quality) error {
+	resolve, err := resolverFor(*j)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(jobsCtx)
+	j.cancel = cancel
+	if err := jobStore.Put(j); err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		defer cancel()
+		trackJob(func() { runBatch(ctx, j.ID, resolve, quality) })
+	}()
+	return nil
+}
+
+// runBatch создаёт дочерние задачи, ведёт общий прогресс и по завершении собирает ZIP
+func runBatch(ctx context.Context, parentID string, resolve batchResolver, quality parser.Quality) {
+	parent, ok := getJob(parentID)
+	if !ok || parent.finished() {
+		return
+	}
+	setJob(parentID, func(j *Job) { j.Status = JobRunning })
+
+	// после перезапуска дочерние уже есть: ждущие очереди снова ставим сами, как новые
+	var pending []string
+	children := parent.Children
+	for _, id := range children {
+		if c, ok := getJob(id); ok && c.Status == JobQueued {
+			pending = append(pending, id)
+		}
+	}
+	if len(children) == 0 {
+		title, videos, err := resolve(ctx)
+		if err != nil {
+			finishBatchError(ctx, parentID, err)
+			return
+		}
+		log.Printf("📃 Задача %s: %d видео в «%s»", parentID, len(videos), title)
+
+		for _, v := range videos {
+			id := newChildJob(parent, v, quality)
+			children = append(children, id)
+			pending = append(pending, id)
+		}
+		setJob(parentID, func(j *Job) {
+			j.Title = title
+			j.Children = children
+		})
+	}
+
+	t := time.NewTicker(batchPoll)
+	defer t.Stop()
+	for {
+		pending = enqueuePending(pending, children, quality)
+		if batchProgress(parentID, children) && len(pending) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			finishBatchStopped(parentID, pending)
+			return
+		case <-t.C:
+		}
+	}
+
+	finishBatch(parentID, children)
+}
+
+// newChildJob сохраняет дочернюю задачу с форматом родителя и возвращает её ID.
+// В очередь её ставит enqueuePending — по свежей копии из хранилища.
+func newChildJob(parent Job, v parser.ListedVideo, quality parser.Quality) string {
+	j := Job{
+		ID:        newID(),
+		CreatedAt: time.Now(),
+		Status:    JobQueued,
+		URL:       v.URL,
+		Quality:   quality.String(),
+		Title:     v.Title,
+		ParentID:  parent.ID,
+		Watchers:  []string{parent.ID}, // к видео набора могут присоединиться и отдельные запросы
+		JobFormat: parent.JobFormat,
+	}
+	if ref, err := parser.ParseVideoURL(v.URL); err == nil {
+		j.Key = jobKey(ref, jobOptions(j, quality))
+	}
+	// в хранилище — своя копия: дальше задачу меняют только через setJob
+	if err := jobStore.Put(&j); err != nil {
+		log.Printf("⚠️ Не удалось сохранить задачу %s: %v", j.ID, err)
+	}
+	return j.ID
+}
+
+// enqueuePending ставит дочерние задачи в очередь, пока в ней есть место; остальные ждут следующего круга.
+// Набор держит в очереди не больше MAX_CONCURRENT_JOBS своих видео, чтобы большой плейлист
+// не занимал её часами и отдельные загрузки других пользователей тоже проходили.
+func enqueuePending(pending, children []string, quality parser.Quality) []string {
+	free := queue.workers - queue.waiting(children)
+	for len(pending) > 0 && free > 0 {
+		j, ok := getJob(pending[0])
+		if !ok || j.Status != JobQueued {
+			// отменили, пока ждала
+			pending = pending[1:]
+			continue
+		}
+		if err := enqueueJob(&j, jobOptions(j, quality)); err != nil {
+			if !errors.Is(err, errQueueFull) {
+				log.Printf("⚠️ Задача %s не поставлена в очередь: %v", j.ID, err)
+			}
+			break
+		}
+		pending = pending[1:]
+		free--
+	}
+	return pending
+}
+
+// batchProgress обновляет общий процент родителя; true — все дочерние завершились
+func batchProgress(parentID string, children []string) bool {
+	var sum float64
+	done := 0
+	for _, id := range children {
+		c, ok := getJob(id)
+		if !ok || c.finished() {
+			// ошибка или отмена тоже конец пути для общего прогресса
+			sum += 100
+			done++
+			continue
+		}
+		sum += c.Percent
+	}
+	setJob(parentID, func(j *Job) {
+		if len(children) > 0 {
+			j.Percent = sum / float64(len(children))
+		}
+		j.ChildrenDone = done
+	})
+	return done == len(children)
+}
+
+// finishBatch собирает готовые файлы дочерних задач в ZIP
+func finishBatch(parentID string, children []string) {
+	parent, ok := getJob(parentID)
+	if !ok || parent.finished() {
+		return
+	}
+
+	var files []string
+	done := 0
+	for _, id := range children {
+		if c, found := getJob(id); found && c.Status == JobDone && c.FileName != "" {
+			files = append(files, c.files()...)
+			done++
+		}
+	}
+	if len(files) == 0 {
+		setJob(parentID, func(j *Job) {
+			j.Status = JobError
+			j.ErrorText = "Не удалось скачать ни одного видео."
+			j.FinishedAt = time.Now()
+		})
+		return
+	}
+
+	zipName := parser.ArchiveName(parent.Title, parentID)
+	if err := writeZip(filepath.Join("downloads", zipName), files); err != nil {
+		log.Printf("⚠️ Задача %s: ZIP не собран: %v", parentID, err)
+		zipName = "" // файлы по отдельности всё равно доступны
+	}
+
+	setJob(parentID, func(j *Job) {
+		j.Status = JobDone
+		j.Percent = 100
+		j.FileName = zipName
+		j.Files = files
+		j.FinishedAt = time.Now()
+		if n := len(children) - done; n > 0 {
+			j.ErrorText = fmt.Sprintf("Не удалось скачать видео: %d из %d.", n, len(children))
+		}
+	})
+	log.Printf("📦 Задача %s: готово %d из %d видео", parentID, done, len(children))
+}
+
+func finishBatchError(ctx context.Context, parentID string, err error) {
+	if ctx.Err() != nil {
+		finishBatchStopped(parentID, nil)
+		return
+	}
+	log.Printf("❌ Задача %s: не удалось получить список видео: %v", parentID, err)
+	setJob(parentID, func(j *Job) {
+		j.Status = JobError
+		j.ErrorText = jobErrorText(err)
+		j.FinishedAt = time.Now()
+	})
+}
+
+// finishBatchStopped — родитель отменён или сервер останавливается
+func finishBatchStopped(parentID string, pending []string) {
+	if jobsCtx.Err() != nil {
+		// дочерние, не успевшие в очередь, поставит возобновлённый после перезапуска родитель
+		log.Printf("⏸️ Задача %s прервана остановкой сервера", parentID)
+		return
+	}
+	for _, id := range pending {
+		releaseJob(id, parentID)
+	}
+	log.Printf("🚫 Задача %s отменена", parentID)
+}
+
+// writeZip упаковывает файлы из downloads/ без сжатия — видео и так сжато
+func writeZip(path string, files []string) error {
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range files {
+		if err := addZipFile(zw, filepath.Join("downloads", name), name); err != nil {
+			zw.Close()
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func addZipFile(zw *zip.Writer, src, name string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Store
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
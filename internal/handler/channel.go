@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rutube-downloader/internal/parser"
+)
+
+// channelFilterFromForm читает фильтры канала: since (ГГГГ-ММ-ДД), max_count,
+// title_re, min_dur и max_dur (в минутах). Пустые поля не ограничивают.
+func channelFilterFromForm(r *http.Request) (*parser.ChannelFilter, error) {
+	var f parser.ChannelFilter
+
+	if s := strings.TrimSpace(r.FormValue("since")); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return nil, errors.New("дата должна быть в формате ГГГГ-ММ-ДД")
+		}
+		f.Since = t
+	}
+
+	n, err := formInt(r, "max_count")
+	if err != nil {
+		return nil, errors.New("количество видео должно быть числом")
+	}
+	f.MaxCount = n
+
+	f.TitleRegex = strings.TrimSpace(r.FormValue("title_re"))
+
+	minDur, err := formInt(r, "min_dur")
+	if err != nil {
+		return nil, errors.New("длительность указывается в минутах")
+	}
+	maxDur, err := formInt(r, "max_dur")
+	if err != nil {
+		return nil, errors.New("длительность указывается в минутах")
+	}
+	f.MinDuration = time.Duration(minDur) * time.Minute
+	f.MaxDuration = time.Duration(maxDur) * time.Minute
+
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// formInt — целое из формы; пустое поле — 0
+func formInt(r *http.Request, name string) (int, error) {
+	s := strings.TrimSpace(r.FormValue(name))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
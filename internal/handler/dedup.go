@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"rutube-downloader/internal/parser"
+)
+
+// dedupMu — поиск подходящей задачи и создание новой выполняются атомарно
+var dedupMu sync.Mutex
+
+// jobKey — одинаковые запросы: то же видео, качество, формат, фрагмент, субтитры, обложка
+// и, для эфира, тот же лимит записи.
+// Ключ приватного ролика входит в ключ задачи — без него чужой готовый файл не отдаём.
+func jobKey(ref parser.VideoRef, o parser.Options) string {
+	key := ref.ID
+	if ref.PrivateKey != "" {
+		key += "?p=" + ref.PrivateKey
+	}
+	key += "|" + o.Quality.String() + "|" + o.FormatKey()
+	if !o.Clip.IsZero() {
+		key += "|" + o.Clip.String()
+	}
+	if o.Subtitles != parser.SubtitlesNone {
+		key += "|subs=" + string(o.Subtitles)
+	}
+	if o.Poster != parser.PosterNone {
+		key += "|poster=" + string(o.Poster)
+	}
+	if o.Live.FromStart {
+		key += "|from_start"
+	}
+	if o.Live.MaxDuration > 0 {
+		key += "|max=" + o.Live.MaxDuration.String()
+	}
+	return key
+}
+
+// findReusableJob ищет задачу с тем же ключом: к ждущей или идущей присоединяемся,
+// готовую отдаём из кеша, пока уборщик не удалил её файл. Готовая запись эфира —
+// прошлое трансляции, а не то, что просят сейчас: её не отдаём.
+func findReusableJob(key string) (Job, bool) {
+	for _, j := range jobStore.All() {
+		if j.Key != key {
+			continue
+		}
+		switch j.Status {
+		case JobQueued, JobRunning:
+			return j, true
+		case JobDone:
+			if j.Live {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join("downloads", j.FileName)); err == nil {
+				return j, true
+			}
+		}
+	}
+	return Job{}, false
+}
@@ -1,22 +1,30 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"rutube-downloader/internal/parser"
 )
 
+// jobAttempts — сколько раз пробуем извлечь видео, прежде чем сдаться
+const jobAttempts = 3
+
 type ResultPageData struct {
 	Error       string
 	OriginalURL string
 	VideoLink   string
 	JobID       string
+	Token       string // токен запросившего: с ним страница может отменить задачу
 }
 
 func newID() string {
@@ -43,6 +51,140 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	quality, err := parser.ParseQuality(r.FormValue("quality"))
+	if err != nil {
+		renderError(w, "Неизвестное качество видео")
+		return
+	}
+
+	// Видео целиком, только звук (m4a без перекодирования, mp3 с выбранным битрейтом)
+	// или GIF / зацикленное видео из фрагмента
+	format := r.FormValue("format")
+	anim, isAnim := parser.ParseAnimFormat(format)
+	if isAnim {
+		format = ""
+	}
+	audio, err := parser.ParseAudioFormat(format)
+	if err != nil {
+		renderError(w, "Неизвестный формат загрузки")
+		return
+	}
+	bitrate, err := parser.ParseAudioBitrate(r.FormValue("audio_bitrate"))
+	if err != nil {
+		renderError(w, "Битрейт MP3: "+err.Error())
+		return
+	}
+	subs, err := parser.ParseSubtitleMode(r.FormValue("subs"))
+	if err != nil {
+		renderError(w, "Неизвестный режим субтитров")
+		return
+	}
+	poster, err := parser.ParsePosterMode(r.FormValue("poster"))
+	if err != nil {
+		renderError(w, "Неизвестный режим обложки")
+		return
+	}
+	// Пресет перекодирует видео под iPhone/телевизор; к режиму «только звук» не относится
+	preset, err := parser.ParsePreset(r.FormValue("preset"))
+	if err != nil {
+		renderError(w, "Неизвестный пресет перекодирования")
+		return
+	}
+	if preset != parser.PresetNone && audio != parser.AudioNone {
+		renderError(w, "Пресет перекодирования доступен только для видео")
+		return
+	}
+	container, err := parser.ParseContainer(r.FormValue("container"))
+	if err != nil {
+		renderError(w, "Неизвестный контейнер")
+		return
+	}
+	base := Job{JobFormat: JobFormat{Audio: string(audio), AudioBitrate: bitrate, Subtitles: string(subs), Poster: string(poster), Preset: string(preset), Container: string(container)}}
+
+	// Эфир пишется до конца трансляции, остановки или лимита в минутах
+	maxMin, err := formInt(r, "max_duration")
+	if err != nil || maxMin < 0 {
+		renderError(w, "Длительность записи указывается в минутах")
+		return
+	}
+	base.MaxDuration = time.Duration(maxMin) * time.Minute
+	base.FromStart = r.FormValue("from_start") != ""
+
+	// Фрагмент: только для одного ролика
+	clip, err := parser.ParseClip(r.FormValue("start"), r.FormValue("end"))
+	if err != nil {
+		renderError(w, "Фрагмент: "+err.Error())
+		return
+	}
+
+	// GIF: фрагмент задаётся началом и длительностью; субтитры и обложка к нему не относятся
+	if isAnim {
+		a, animClip, err := animFromForm(r, anim)
+		if err != nil {
+			renderError(w, "GIF: "+err.Error())
+			return
+		}
+		base.Anim, base.AnimWidth, base.AnimFPS = string(a.Format), a.Width, a.FPS
+		base.Subtitles, base.Poster = "", ""
+		clip = animClip
+	}
+
+	// кодеки должны помещаться в контейнер: WebM без H.264, пресеты — только MP4, GIF — до минуты
+	base.ClipStart, base.ClipEnd = clip.Start, clip.End
+	if err := jobOptions(base, quality).Validate(); err != nil {
+		renderError(w, "Формат: "+err.Error())
+		return
+	}
+
+	_, plErr := parser.ParsePlaylistURL(url)
+	_, chErr := parser.ParseChannelURL(url)
+	if isAnim && (plErr == nil || chErr == nil) {
+		renderError(w, "GIF делается из одного ролика, а не из плейлиста или канала")
+		return
+	}
+	if !clip.IsZero() && (plErr == nil || chErr == nil) {
+		renderError(w, "Фрагмент вырезается из одного ролика, а не из плейлиста или канала")
+		return
+	}
+
+	// Плейлист — родительская задача с загрузкой каждого ролика
+	if plErr == nil {
+		base.URL, base.Kind = url, KindPlaylist
+		startBatchJob(w, &base, quality)
+		return
+	}
+
+	// Канал — то же, но ролики отбираются по фильтрам из формы
+	if chErr == nil {
+		filter, err := channelFilterFromForm(r)
+		if err != nil {
+			renderError(w, "Фильтры канала: "+err.Error())
+			return
+		}
+		base.URL, base.Kind, base.Filter = url, KindChannel, filter
+		startBatchJob(w, &base, quality)
+		return
+	}
+
+	ref, err := parser.ParseVideoURL(url)
+	if err != nil {
+		renderError(w, "Введите корректную ссылку на RuTube")
+		return
+	}
+
+	// То же видео в том же качестве и формате уже качается или готово — отдаём ту задачу
+	key := jobKey(ref, jobOptions(base, quality))
+	token := newID()
+	dedupMu.Lock()
+	if j, ok := findReusableJob(key); ok {
+		// ещё один запросивший со своим токеном: его отмена не оборвёт загрузку остальным
+		watchJob(j.ID, token)
+		dedupMu.Unlock()
+		log.Printf("♻️ %s: используем задачу %s (%s)", key, j.ID, j.Status)
+		renderResult(w, url, j.ID, token)
+		return
+	}
+
 	// Создаём задачу и сразу возвращаем страницу с прогресс-баром.
 	jobID := newID()
 	job := &Job{
@@ -50,47 +192,45 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: time.Now(),
 		Status:    JobQueued,
 		Percent:   0,
+		Watchers:  []string{token},
+		URL:       url,
+		Quality:   quality.String(),
+		Key:       key,
+		JobFormat: base.JobFormat,
 	}
-	jobsMu.Lock()
-	jobs[jobID] = job
-	jobsMu.Unlock()
-
-	// Фоновая горутина: парсинг + ffmpeg
-	go func(jobID, videoURL string) {
-		setJob(jobID, func(j *Job) {
-			j.Status = JobRunning
-			j.Percent = 0
-		})
 
-		// ExtractMP4WithProgress отдаёт имя файла и обновляет проценты через callback
-		fileName, err := parser.ExtractMP4WithProgress(videoURL, func(done, total float64) {
-			// total может быть 0 в начале — защищаемся
-			if total > 0 {
-				p := (done / total) * 100
-				if p > 100 {
-					p = 100
-				}
-				setJob(jobID, func(j *Job) { j.Percent = p })
-			}
-		})
+	// Ставим в очередь: парсинг + ffmpeg выполнит воркер, когда освободится
+	err = enqueueJob(job, jobOptions(*job, quality))
+	dedupMu.Unlock()
+	if err != nil {
+		log.Printf("⚠️ Задача %s отклонена: %v", jobID, err)
+		_ = jobStore.Delete(jobID)
+		renderError(w, "Сервер перегружен: слишком много загрузок в очереди. Попробуйте через несколько минут.")
+		return
+	}
 
-		if err != nil || fileName == "" {
-			log.Printf("❌ Ошибка при парсинге RuTube: %v", err)
-			setJob(jobID, func(j *Job) {
-				j.Status = JobError
-				j.ErrorText = "Не удалось извлечь видео. Попробуйте позже."
-			})
-			return
-		}
+	renderResult(w, url, jobID, token)
+}
 
-		setJob(jobID, func(j *Job) {
-			j.Status = JobDone
-			j.Percent = 100
-			j.FileName = fileName
-		})
-	}(jobID, url)
+// startBatchJob создаёт родительскую задачу для набора роликов и отдаёт страницу прогресса.
+// В job заполнены URL, Kind, параметры набора и формат — его наследуют дочерние.
+func startBatchJob(w http.ResponseWriter, job *Job, quality parser.Quality) {
+	job.ID = newID()
+	job.CreatedAt = time.Now()
+	job.Status = JobQueued
+	job.Quality = quality.String()
+	token := newID()
+	job.Watchers = []string{token}
+	if err := startBatch(job, quality); err != nil {
+		log.Printf("⚠️ Задача %s отклонена: %v", job.ID, err)
+		renderError(w, "Введите корректную ссылку на RuTube")
+		return
+	}
+	renderResult(w, job.URL, job.ID, token)
+}
 
-	// Рендерим страницу с прогресс-баром и авто-подстановкой ссылки по готовности
+// renderResult — страница с прогресс-баром и авто-подстановкой ссылки по готовности
+func renderResult(w http.ResponseWriter, url, jobID, token string) {
 	tmpl, err := template.ParseFiles("internal/templates/result.html")
 	if err != nil {
 		http.Error(w, "Ошибка шаблона", http.StatusInternalServerError)
@@ -101,12 +241,188 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 		OriginalURL: url,
 		VideoLink:   "", // появится, когда задача завершится
 		JobID:       jobID,
+		Token:       token,
 	}
 	if err := tmpl.Execute(w, data); err != nil {
 		log.Printf("❌ Ошибка при отрисовке шаблона: %v", err)
 	}
 }
 
+// jobOptions — параметры парсера для задачи: её формат, а для видео набора — запрет эфира без лимита
+func jobOptions(j Job, quality parser.Quality) parser.Options {
+	return parser.Options{
+		Quality: quality,
+		JobID:   j.ID,
+		// сегменты задачи переживают сбой и перезапуск — повторная попытка докачает только недостающие
+		WorkDir:      filepath.Join(parser.WorkRoot, j.ID),
+		Audio:        parser.AudioFormat(j.Audio),
+		AudioBitrate: j.AudioBitrate,
+		Clip:         parser.Clip{Start: j.ClipStart, End: j.ClipEnd},
+		Subtitles:    parser.SubtitleMode(j.Subtitles),
+		Poster:       parser.PosterMode(j.Poster),
+		Preset:       parser.Preset(j.Preset),
+		Container:    parser.Container(j.Container),
+		Anim:         parser.AnimOptions{Format: parser.AnimFormat(j.Anim), Width: j.AnimWidth, FPS: j.AnimFPS},
+		Live:         parser.LiveOptions{MaxDuration: j.MaxDuration, FromStart: j.FromStart, RequireLimit: j.ParentID != ""},
+	}
+}
+
+// enqueueJob сохраняет задачу и ставит её в очередь
+func enqueueJob(j *Job, opts parser.Options) error {
+	ctx, cancel := context.WithCancel(jobsCtx)
+	j.cancel = cancel
+	j.stop = make(chan struct{})
+	j.Stopping = false
+	opts.Live.Stop = j.stop
+	if err := jobStore.Put(j); err != nil {
+		cancel()
+		return err
+	}
+	err := queue.push(j.ID, func() {
+		defer cancel()
+		trackJob(func() { runJob(ctx, j.ID, j.URL, opts) })
+	})
+	if err != nil {
+		cancel()
+	}
+	return err
+}
+
+// requeueJob поднимает незавершённую задачу после перезапуска сервера
+func requeueJob(j Job) {
+	quality, err := parser.ParseQuality(j.Quality)
+	if err == nil {
+		j.Status = JobQueued
+		j.Percent = 0
+		if j.Kind != "" {
+			err = startBatch(&j, quality)
+		} else {
+			err = enqueueJob(&j, jobOptions(j, quality))
+		}
+	}
+	if err != nil {
+		log.Printf("❌ Не удалось возобновить задачу %s: %v", j.ID, err)
+		setJob(j.ID, func(j *Job) {
+			j.Status = JobError
+			j.ErrorText = "Не удалось возобновить загрузку после перезапуска."
+			j.FinishedAt = time.Now()
+		})
+		return
+	}
+	log.Printf("🔁 Задача %s снова в очереди", j.ID)
+}
+
+// runJob — фоновая часть задачи: парсинг + ffmpeg с обновлением прогресса
+func runJob(ctx context.Context, jobID, videoURL string, opts parser.Options) {
+	started := false
+	setJob(jobID, func(j *Job) {
+		if j.Status == JobQueued {
+			j.Status = JobRunning
+			j.Percent = 0
+			started = true
+		}
+	})
+	if !started {
+		// отменили, пока ждала в очереди
+		return
+	}
+
+	onProgress := func(done, total float64) {
+		// total может быть 0 в начале — защищаемся
+		if total > 0 {
+			p := (done / total) * 100
+			if p > 100 {
+				p = 100
+			}
+			setJob(jobID, func(j *Job) { j.Percent = p })
+		}
+	}
+	opts.Live.OnProgress = func(elapsed time.Duration, bytes int64) {
+		setJob(jobID, func(j *Job) {
+			j.Live = true
+			j.Elapsed = elapsed.Seconds()
+			j.Bytes = bytes
+		})
+	}
+
+	// Download отдаёт имена файлов и обновляет проценты через callback;
+	// при сбое пробуем ещё раз — скачанные сегменты берутся из чекпоинта
+	var res *parser.Result
+	var err error
+	for attempt := 1; attempt <= jobAttempts; attempt++ {
+		res, err = parser.Download(ctx, videoURL, opts, onProgress)
+		if err == nil || ctx.Err() != nil || errors.Is(err, parser.ErrPrivateVideo) || errors.Is(err, parser.ErrCodecMismatch) ||
+			errors.Is(err, parser.ErrLiveNoLimit) {
+			break
+		}
+		log.Printf("⚠️ Задача %s, попытка %d/%d: %v", jobID, attempt, jobAttempts, err)
+	}
+	fileName := ""
+	if res != nil {
+		fileName = res.FileName
+	}
+
+	// Отменена пользователем — убираем недокачанное, статус уже выставлен
+	if j, ok := getJob(jobID); ok && j.Status == JobCancelled {
+		log.Printf("🚫 Задача %s отменена", jobID)
+		_ = os.RemoveAll(opts.WorkDir)
+		if res != nil {
+			// тот же файл мог достаться другой задаче с другим ключом — её не обделяем
+			inUse := filesInUse(func(j Job) bool { return j.ID == jobID })
+			for _, name := range res.Files() {
+				if !inUse[name] {
+					_ = os.Remove(filepath.Join("downloads", name))
+				}
+			}
+		}
+		return
+	}
+
+	// Сервер останавливается — статус не трогаем, после перезапуска задача продолжится
+	if jobsCtx.Err() != nil {
+		log.Printf("⏸️ Задача %s прервана остановкой сервера", jobID)
+		return
+	}
+
+	if err != nil || fileName == "" {
+		log.Printf("❌ Ошибка при парсинге RuTube: %v", err)
+		setJob(jobID, func(j *Job) {
+			j.Status = JobError
+			j.ErrorText = jobErrorText(err)
+			j.FinishedAt = time.Now()
+		})
+		return
+	}
+
+	setJob(jobID, func(j *Job) {
+		j.Status = JobDone
+		j.Percent = 100
+		j.FileName = fileName
+		j.SubtitleFiles = res.Subtitles
+		j.PosterFile = res.Poster
+		j.FinishedAt = time.Now()
+	})
+}
+
+// jobErrorText — сообщение для пользователя по ошибке парсера
+func jobErrorText(err error) string {
+	if errors.Is(err, parser.ErrPrivateVideo) {
+		return privateVideoText
+	}
+	if errors.Is(err, parser.ErrLiveNoLimit) {
+		return "Это прямой эфир: в плейлисте или канале он записывается только с лимитом длительности."
+	}
+	if errors.Is(err, parser.ErrCodecMismatch) {
+		return "Формат: " + err.Error() + "."
+	}
+	if errors.Is(err, parser.ErrEmptyList) {
+		return "Не нашлось ни одного видео для загрузки — проверьте ссылку и фильтры."
+	}
+	return "Не удалось извлечь видео. Попробуйте позже."
+}
+
+const privateVideoText = "Это приватное видео: нужна полная ссылка с ключом доступа (…?p=…). Проверьте, что ключ скопирован целиком."
+
 func renderError(w http.ResponseWriter, message string) {
 	tmpl, err := template.ParseFiles("internal/templates/result.html")
 	if err != nil {
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+
+	"rutube-downloader/internal/parser"
+)
+
+// DownloadsHandler отдаёт готовые файлы из dir с Content-Type по нашей таблице:
+// системная не знает .mkv, а .ts отдаёт как исходник TypeScript
+func DownloadsHandler(dir string) http.Handler {
+	files := http.StripPrefix("/downloads/", http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t := parser.MIMEType(r.URL.Path); t != "" {
+			// FileServer не трогает заданный тип; ошибки (404) перезапишут его на text/plain
+			w.Header().Set("Content-Type", t)
+		}
+		files.ServeHTTP(w, r)
+	})
+}
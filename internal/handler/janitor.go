@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"rutube-downloader/internal/parser"
+)
+
+// Срок жизни задач и файлов — DOWNLOAD_TTL_MIN минут; не задано или 0 — хранить вечно
+const janitorInterval = time.Minute
+
+// recommendedTTL — что советуем в DOWNLOAD_TTL_MIN, если диск не резиновый
+const recommendedTTL = 60
+
+func ttlFromEnv() time.Duration {
+	s := strings.TrimSpace(os.Getenv("DOWNLOAD_TTL_MIN"))
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Printf("⚠️ DOWNLOAD_TTL_MIN=%q — ожидалось число минут, автоочистка выключена", s)
+		return 0
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// StartJanitor запускает единственный фоновый уборщик: истёкшие задачи, их файлы
+// и осиротевшие файлы в downloads/ и work/. Срок считается от сохранённых отметок
+// времени и mtime, поэтому после перезапуска уборка продолжается с того же места.
+func StartJanitor(ctx context.Context) {
+	ttl := ttlFromEnv()
+	if ttl == 0 {
+		log.Printf("🧹 Автоочистка выключена: файлы в downloads/ копятся. Рекомендуем DOWNLOAD_TTL_MIN=%d", recommendedTTL)
+		return
+	}
+	log.Printf("🧹 Автоочистка: задачи и файлы живут %v", ttl)
+
+	go func() {
+		t := time.NewTicker(janitorInterval)
+		defer t.Stop()
+		for {
+			sweep(ttl, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func sweep(ttl time.Duration, now time.Time) {
+	jobs := jobStore.All()
+	active := map[string]bool{}
+	for _, j := range jobs {
+		if !j.finished() {
+			active[j.ID] = true
+		}
+	}
+	var expired []Job
+	for _, j := range jobs {
+		// видео набора нужны, пока родитель не собрал ZIP
+		if active[j.ID] || active[j.ParentID] {
+			continue
+		}
+		since := j.FinishedAt
+		if since.IsZero() {
+			since = j.CreatedAt
+		}
+		if now.Sub(since) >= ttl {
+			expired = append(expired, j)
+		}
+	}
+	// файлы остающихся задач не трогаем: одно имя бывает у задач с разными ключами
+	// ("best" и "<=1080p"), а видео незавершённых наборов ещё пойдут в ZIP
+	gone := map[string]bool{}
+	for _, j := range expired {
+		gone[j.ID] = true
+	}
+	inUse := filesInUse(func(j Job) bool { return gone[j.ID] })
+
+	for _, j := range expired {
+		for _, name := range j.files() {
+			if !inUse[name] {
+				removeExpired(filepath.Join("downloads", name))
+			}
+		}
+		removeExpired(filepath.Join(parser.WorkRoot, j.ID))
+		if err := jobStore.Delete(j.ID); err != nil {
+			log.Printf("⚠️ Не удалось удалить задачу %s: %v", j.ID, err)
+			continue
+		}
+		log.Printf("🧹 Задача %s (%s) истекла", j.ID, j.Status)
+	}
+
+	// то, что не привязано к задачам: старые запуски, ExtractMP4 без веб-задачи
+	sweepDir("downloads", ttl, now, inUse)
+	sweepDir(parser.WorkRoot, ttl, now, active)
+}
+
+// sweepDir удаляет записи каталога старше ttl, кроме перечисленных в keep (файлы и рабочие директории активных задач)
+func sweepDir(dir string, ttl time.Duration, now time.Time, keep map[string]bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if keep[e.Name()] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < ttl {
+			continue
+		}
+		removeExpired(filepath.Join(dir, e.Name()))
+	}
+}
+
+func removeExpired(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if err := os.RemoveAll(path); err != nil {
+		log.Printf("⚠️ Не удалось удалить %s: %v", path, err)
+		return
+	}
+	log.Printf("🧹 Удалено: %s", path)
+}
@@ -1,19 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
+
+	"rutube-downloader/internal/parser"
 )
 
 type JobStatus string
 
 const (
-	JobQueued  JobStatus = "queued"
-	JobRunning JobStatus = "running"
-	JobDone    JobStatus = "done"
-	JobError   JobStatus = "error"
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobError     JobStatus = "error"
+	JobCancelled JobStatus = "cancelled"
 )
 
 type Job struct {
@@ -23,25 +28,213 @@ type Job struct {
 	Percent   float64   `json:"percent"`   // 0..100
 	FileName  string    `json:"file_name"` // когда готов
 	ErrorText string    `json:"error,omitempty"`
+
+	FinishedAt time.Time `json:"finished_at"` // от неё считается срок жизни
+
+	// что качаем — нужно, чтобы поднять задачу после перезапуска
+	URL     string `json:"url"`
+	Quality string `json:"quality,omitempty"`
+	Key     string `json:"key,omitempty"` // видео|качество|формат — для переиспользования
+
+	JobFormat // что выбрал пользователь; видео набора получают его целиком
+
+	SubtitleFiles []string `json:"subtitle_files,omitempty"` // готовые SRT/VTT
+	PosterFile    string   `json:"poster_file,omitempty"`    // сохранённая обложка
+
+	// прямой эфир: вместо процентов — сколько записано
+	Live     bool    `json:"live,omitempty"`
+	Elapsed  float64 `json:"elapsed,omitempty"` // секунды эфира
+	Bytes    int64   `json:"bytes,omitempty"`
+	Stopping bool    `json:"stopping,omitempty"`
+
+	QueuePosition int `json:"queue_position,omitempty"` // место в очереди, пока задача ждёт
+
+	// Watchers — токены запросов (у видео набора — ID родителя), которые ждут задачу.
+	// Отменить её может только ждущий, и отмена одним из нескольких лишь отвязывает его.
+	// Наружу токены не отдаются — иначе любой, кто знает ID задачи, отменил бы её за всех.
+	Watchers []string `json:"tokens,omitempty"`
+
+	// набор роликов (плейлист, канал): родительская задача ведёт дочерние, по задаче на видео
+	Kind         string   `json:"kind,omitempty"` // "" — одно видео
+	Title        string   `json:"title,omitempty"`
+	ParentID     string   `json:"parent_id,omitempty"`
+	Children     []string `json:"children,omitempty"`
+	ChildrenDone int      `json:"children_done,omitempty"` // сколько дочерних завершилось
+	Files        []string `json:"files,omitempty"`         // готовые файлы дочерних; FileName — ZIP
+
+	Filter *parser.ChannelFilter `json:"filter,omitempty"` // отбор роликов канала
+
+	cancel context.CancelFunc // обрывает загрузку задачи
+	stop   chan struct{}      // закрытие заканчивает запись эфира с сохранением
+}
+
+// JobFormat — формат результата из формы: звук, фрагмент, пресет, контейнер, анимация,
+// субтитры, обложка и запись эфира. Одна структура на всё, чтобы родитель набора передавал
+// дочерним задачам всё сразу, а jobOptions и ключ задачи не пропускали поля.
+type JobFormat struct {
+	Audio        string `json:"audio,omitempty"`         // m4a/mp3 — только звук
+	AudioBitrate int    `json:"audio_bitrate,omitempty"` // кбит/с для mp3
+
+	ClipStart time.Duration `json:"clip_start,omitempty"` // фрагмент; ClipEnd 0 — до конца
+	ClipEnd   time.Duration `json:"clip_end,omitempty"`
+
+	Preset    string `json:"preset,omitempty"`    // перекодирование под устройство
+	Container string `json:"container,omitempty"` // mp4/mkv/ts/webm; пусто — по бэкенду
+
+	// GIF или зацикленное видео без звука из фрагмента ClipStart–ClipEnd
+	Anim      string `json:"anim,omitempty"` // gif/loop
+	AnimWidth int    `json:"anim_width,omitempty"`
+	AnimFPS   int    `json:"anim_fps,omitempty"`
+
+	Subtitles string `json:"subtitles,omitempty"` // files/embed
+	Poster    string `json:"poster,omitempty"`    // file/embed
+
+	MaxDuration time.Duration `json:"max_duration,omitempty"` // эфир: 0 — пока не остановят
+	FromStart   bool          `json:"from_start,omitempty"`   // эфир: с начала DVR-окна
+}
+
+// finished — задача уже в конечном состоянии
+func (j *Job) finished() bool {
+	return j.Status == JobDone || j.Status == JobError || j.Status == JobCancelled
+}
+
+// files — файлы задачи в downloads/: результат, субтитры и обложка
+func (j *Job) files() []string {
+	var out []string
+	if j.FileName != "" {
+		out = append(out, j.FileName)
+	}
+	out = append(out, j.SubtitleFiles...)
+	if j.PosterFile != "" {
+		out = append(out, j.PosterFile)
+	}
+	return out
 }
 
+// filesInUse — файлы в downloads/, на которые ссылаются задачи, кроме пропущенных skip
+// и отменённых или упавших: их файлы уже никому не нужны
+func filesInUse(skip func(Job) bool) map[string]bool {
+	out := map[string]bool{}
+	for _, j := range jobStore.All() {
+		if skip(j) || j.Status == JobCancelled || j.Status == JobError {
+			continue
+		}
+		for _, name := range j.files() {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// jobsCtx — общий контекст фоновых задач; отменяется при остановке сервера
+var jobsCtx, cancelJobs = context.WithCancel(context.Background())
+
+// jobsWG — идущие задачи и координаторы наборов. jobsMu не даёт запустить новую
+// после CancelJobs, чтобы WaitJobs не разминулся с поздним Add.
 var (
-	jobsMu sync.RWMutex
-	jobs   = map[string]*Job{}
+	jobsWG sync.WaitGroup
+	jobsMu sync.Mutex
 )
 
-func setJob(id string, upd func(*Job)) {
+// trackJob выполняет фоновую работу задачи так, чтобы WaitJobs её дождался.
+// После остановки сервера не запускает ничего — задача продолжится после перезапуска.
+func trackJob(run func()) {
+	jobsMu.Lock()
+	if jobsCtx.Err() != nil {
+		jobsMu.Unlock()
+		return
+	}
+	jobsWG.Add(1)
+	jobsMu.Unlock()
+	defer jobsWG.Done()
+	run()
+}
+
+// CancelJobs прерывает все фоновые загрузки: HTTP-запросы обрываются, ffmpeg убивается
+func CancelJobs() {
 	jobsMu.Lock()
 	defer jobsMu.Unlock()
-	if j, ok := jobs[id]; ok {
-		upd(j)
+	cancelJobs()
+}
+
+// WaitJobs ждёт, пока прерванные задачи закончатся и их ffmpeg завершится, но не дольше timeout.
+// false — кто-то не уложился.
+func WaitJobs(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		jobsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
-func getJob(id string) *Job {
-	jobsMu.RLock()
-	defer jobsMu.RUnlock()
-	return jobs[id]
+func setJob(id string, upd func(*Job)) {
+	jobStore.Update(id, upd)
+}
+
+func getJob(id string) (Job, bool) {
+	return jobStore.Get(id)
+}
+
+// watchJob добавляет ждущего с токеном token к незавершённой задаче
+func watchJob(id, token string) {
+	setJob(id, func(j *Job) {
+		if !j.finished() {
+			// новый массив: снимки из Get делят старый
+			j.Watchers = append(slices.Clip(j.Watchers), token)
+		}
+	})
+}
+
+// releaseJob отвязывает ждущего с токеном token от незавершённой задачи. Последний
+// отвязавшийся отменяет её: помечает отменённой, обрывает загрузку и отвязывает её от видео набора.
+// known — token ждал задачу (повторная отмена тем же токеном уже ничего не делает);
+// cancelled — задача отменена этим вызовом.
+func releaseJob(id, token string) (known, cancelled bool) {
+	var children []string
+	jobStore.Update(id, func(j *Job) {
+		i := slices.Index(j.Watchers, token)
+		if j.finished() || i < 0 {
+			return
+		}
+		known = true
+		j.Watchers = slices.Delete(slices.Clone(j.Watchers), i, i+1)
+		if len(j.Watchers) > 0 {
+			return
+		}
+		j.Status = JobCancelled
+		j.FinishedAt = time.Now()
+		queue.remove(id)
+		if j.cancel != nil {
+			j.cancel()
+		}
+		children = j.Children
+		cancelled = true
+	})
+	// отмена набора отменяет и все его видео, кроме тех, что ждут и другие
+	for _, c := range children {
+		releaseJob(c, id)
+	}
+	return known, cancelled
+}
+
+// stopJob просит запись эфира закончиться: записанное сохранится, задача станет готовой.
+// stopping — false, если задача не пишет эфир или её уже останавливают.
+func stopJob(id string) (found, stopping bool) {
+	found = jobStore.Update(id, func(j *Job) {
+		if j.Status != JobRunning || !j.Live || j.Stopping || j.stop == nil {
+			return
+		}
+		j.Stopping = true
+		close(j.stop)
+		stopping = true
+	})
+	return found, stopping
 }
 
 func ProgressHandler(w http.ResponseWriter, r *http.Request) {
@@ -50,11 +243,15 @@ func ProgressHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id", http.StatusBadRequest)
 		return
 	}
-	j := getJob(id)
-	if j == nil {
+	snap, ok := getJob(id)
+	if !ok {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	if snap.Status == JobQueued {
+		snap.QueuePosition = queue.position(id)
+	}
+	snap.Watchers = nil
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(j)
+	_ = json.NewEncoder(w).Encode(snap)
 }
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+
+	"rutube-downloader/internal/parser"
+)
+
+// Ограничения очереди: MAX_CONCURRENT_JOBS загрузок одновременно, не больше MAX_QUEUED_JOBS ждущих
+const (
+	defaultMaxConcurrent = 2
+	defaultMaxQueued     = 50
+)
+
+var errQueueFull = errors.New("очередь загрузок переполнена")
+
+type queuedTask struct {
+	id  string
+	run func()
+}
+
+// jobQueue — FIFO-очередь задач с фиксированным числом воркеров
+type jobQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []queuedTask
+	once      sync.Once
+	workers   int
+	maxQueued int
+}
+
+var queue = newJobQueue(
+	parser.IntFromEnv("MAX_CONCURRENT_JOBS", defaultMaxConcurrent),
+	parser.IntFromEnv("MAX_QUEUED_JOBS", defaultMaxQueued),
+)
+
+func newJobQueue(workers, maxQueued int) *jobQueue {
+	q := &jobQueue{workers: workers, maxQueued: maxQueued}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push ставит задачу в конец очереди; при переполнении — errQueueFull
+func (q *jobQueue) push(id string, run func()) error {
+	q.once.Do(q.start)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= q.maxQueued {
+		return errQueueFull
+	}
+	q.pending = append(q.pending, queuedTask{id: id, run: run})
+	q.cond.Signal()
+	return nil
+}
+
+func (q *jobQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		go q.worker()
+	}
+}
+
+func (q *jobQueue) worker() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		t := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		t.run()
+	}
+}
+
+// remove убирает ещё не начатую задачу из очереди
+func (q *jobQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, t := range q.pending {
+		if t.id == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// position — место задачи в очереди, начиная с 1; 0 — задача не ждёт
+func (q *jobQueue) position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, t := range q.pending {
+		if t.id == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// waiting — сколько задач из ids ещё ждут в очереди
+func (q *jobQueue) waiting(ids []string) int {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, t := range q.pending {
+		if set[t.id] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JobStore — хранилище задач. Get отдаёт копию, менять задачу можно только через Update.
+type JobStore interface {
+	Get(id string) (Job, bool)
+	Put(j *Job) error
+	Update(id string, upd func(*Job)) bool
+	Delete(id string) error
+	All() []Job
+}
+
+// jobStore — текущее хранилище; по умолчанию в памяти, InitJobStore подключает файл
+var jobStore JobStore = newMemStore()
+
+// --- в памяти ---------------------------------------------------------------
+
+type memStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: map[string]*Job{}}
+}
+
+func (s *memStore) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (s *memStore) Put(j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	return nil
+}
+
+func (s *memStore) Update(id string, upd func(*Job)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if ok {
+		upd(j)
+	}
+	return ok
+}
+
+func (s *memStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *memStore) All() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		out = append(out, *j)
+	}
+	return out
+}
+
+// --- на диске: append-only JSON-лог поверх памяти ---------------------------
+
+// logEntry — одна строка лога: снимок задачи или её удаление
+type logEntry struct {
+	Job    *Job   `json:"job,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+type fileStore struct {
+	*memStore
+	f *os.File
+}
+
+// openFileStore читает лог, сжимает его до текущего состояния и дальше дописывает в конец
+func openFileStore(path string) (*fileStore, error) {
+	mem := newMemStore()
+	if err := replayLog(path, mem); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	// компактный лог: по строке на живую задачу
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	for _, j := range mem.jobs {
+		if err := enc.Encode(logEntry{Job: j}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{memStore: mem, f: f}, nil
+}
+
+func replayLog(path string, mem *memStore) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		var e logEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// недописанная строка после падения — пропускаем
+			log.Printf("⚠️ %s:%d: битая запись: %v", path, n, err)
+			continue
+		}
+		switch {
+		case e.Job != nil:
+			mem.jobs[e.Job.ID] = e.Job
+		case e.Delete != "":
+			delete(mem.jobs, e.Delete)
+		}
+	}
+	return sc.Err()
+}
+
+func (s *fileStore) write(e logEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *fileStore) Put(j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	return s.write(logEntry{Job: j})
+}
+
+// Update пишет в лог только значимые изменения — проценты слишком частые
+func (s *fileStore) Update(id string, upd func(*Job)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	before := *j
+	upd(j)
+	if before.Status != j.Status || before.FileName != j.FileName || before.ErrorText != j.ErrorText ||
+		len(before.Children) != len(j.Children) || len(before.Watchers) != len(j.Watchers) {
+		if err := s.write(logEntry{Job: j}); err != nil {
+			log.Printf("❌ Не удалось сохранить задачу %s: %v", id, err)
+		}
+	}
+	return true
+}
+
+func (s *fileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return s.write(logEntry{Delete: id})
+}
+
+// InitJobStore подключает хранилище задач из JOB_STORE_PATH (по умолчанию data/jobs.jsonl;
+// пустое значение — только память) и ставит обратно в очередь незавершённые задачи.
+// Вызывать после смены рабочей директории — путь относительный.
+func InitJobStore() error {
+	path, set := os.LookupEnv("JOB_STORE_PATH")
+	if !set {
+		path = filepath.Join("data", "jobs.jsonl")
+	}
+	if path == "" {
+		log.Println("🗂️ Задачи хранятся только в памяти")
+		return nil
+	}
+
+	fs, err := openFileStore(path)
+	if err != nil {
+		return fmt.Errorf("хранилище задач %s: %w", path, err)
+	}
+	jobStore = fs
+	log.Printf("🗂️ Хранилище задач: %s", path)
+
+	jobs := fs.All()
+	unfinished := map[string]bool{}
+	for _, j := range jobs {
+		if !j.finished() {
+			unfinished[j.ID] = true
+		}
+	}
+	// видео незавершённого набора ставит в очередь сам набор, по мере места в ней;
+	// сначала возвращаем им статус ожидания, потом поднимаем родителей
+	for _, j := range jobs {
+		if unfinished[j.ID] && unfinished[j.ParentID] && j.Status != JobQueued {
+			setJob(j.ID, func(j *Job) {
+				j.Status = JobQueued
+				j.Percent = 0
+			})
+		}
+	}
+	for _, j := range jobs {
+		if !unfinished[j.ID] || unfinished[j.ParentID] {
+			continue
+		}
+		requeueJob(j)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnimFormat — короткий ролик для соцсетей из фрагмента видео
+type AnimFormat string
+
+const (
+	AnimNone AnimFormat = ""
+	AnimGIF  AnimFormat = "gif"  // анимированный GIF с подобранной палитрой
+	AnimLoop AnimFormat = "loop" // MP4 без звука, который плееры крутят по кругу
+)
+
+// Пределы анимации: GIF длиннее минуты или шире 1280 весит сотни мегабайт
+const (
+	defaultAnimWidth = 480
+	minAnimWidth     = 64
+	maxAnimWidth     = 1280
+	defaultAnimFPS   = 12
+	maxAnimFPS       = 30
+	maxAnimDuration  = time.Minute
+)
+
+// AnimOptions — формат, ширина и частота кадров анимации; нулевые поля — по умолчанию
+type AnimOptions struct {
+	Format AnimFormat
+	Width  int
+	FPS    int
+}
+
+// ParseAnimFormat — "gif" и "loop"; ok=false — это не анимация (видео или звук)
+func ParseAnimFormat(s string) (AnimFormat, bool) {
+	switch f := AnimFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case AnimGIF, AnimLoop:
+		return f, true
+	}
+	return AnimNone, false
+}
+
+// ParseAnimOptions разбирает ширину и fps из формы; пусто — по умолчанию
+func ParseAnimOptions(f AnimFormat, width, fps string) (AnimOptions, error) {
+	a := AnimOptions{Format: f}
+	var err error
+	if a.Width, err = animInt(width, minAnimWidth, maxAnimWidth); err != nil {
+		return AnimOptions{}, fmt.Errorf("ширина от %d до %d пикселей", minAnimWidth, maxAnimWidth)
+	}
+	if a.FPS, err = animInt(fps, 1, maxAnimFPS); err != nil {
+		return AnimOptions{}, fmt.Errorf("частота от 1 до %d кадров в секунду", maxAnimFPS)
+	}
+	return a, nil
+}
+
+func animInt(s string, lo, hi int) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < lo || n > hi {
+		return 0, errors.New("вне пределов")
+	}
+	return n, nil
+}
+
+func (a AnimOptions) width() int {
+	if a.Width > 0 {
+		return a.Width
+	}
+	return defaultAnimWidth
+}
+
+func (a AnimOptions) fps() int {
+	if a.FPS > 0 {
+		return a.FPS
+	}
+	return defaultAnimFPS
+}
+
+// String — "480px 12fps", для зацикленного MP4 — "loop 480px 12fps"
+func (a AnimOptions) String() string {
+	s := fmt.Sprintf("%dpx %dfps", a.width(), a.fps())
+	if a.Format == AnimLoop {
+		s = "loop " + s
+	}
+	return s
+}
+
+func (a AnimOptions) ext() string {
+	if a.Format == AnimGIF {
+		return ".gif"
+	}
+	return ".mp4"
+}
+
+// codecArgs — без звука, с нужными fps и шириной; меньшие ролики не растягиваются
+func (a AnimOptions) codecArgs() []string {
+	scale := fmt.Sprintf("fps=%d,scale='min(%d,iw)':-2:flags=lanczos", a.fps(), a.width())
+	if a.Format == AnimGIF {
+		// своя палитра на ролик: стандартные 256 цветов GIF дают полосы на градиентах
+		return []string{"-an", "-vf", scale + ",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=5",
+			"-c:v", "gif", "-loop", "0"}
+	}
+	return []string{"-an", "-vf", scale, "-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-preset", "veryfast", "-crf", "23", "-movflags", "+faststart"}
+}
+
+// validateAnim — анимация делается из фрагмента ролика и не сочетается с остальными форматами
+func (o Options) validateAnim() error {
+	if o.Audio != AudioNone || o.Preset != PresetNone || o.Container != ContainerAuto {
+		return errors.New("GIF и зацикленное видео не сочетаются со звуком, пресетами и контейнерами")
+	}
+	if o.Subtitles != SubtitlesNone || o.Poster != PosterNone {
+		return errors.New("к GIF и зацикленному видео субтитры и обложка не прилагаются")
+	}
+	if o.Clip.End <= o.Clip.Start {
+		return errors.New("для GIF нужна длительность фрагмента")
+	}
+	if o.Clip.End-o.Clip.Start > maxAnimDuration {
+		return fmt.Errorf("фрагмент для GIF — не длиннее %v", maxAnimDuration)
+	}
+	return nil
+}
+
+// pickAnimSource — самый лёгкий вариант не уже нужной ширины: качать 1080p ради GIF в 480 незачем
+func pickAnimSource(ctx context.Context, m3u8url string, width int) (Variant, error) {
+	variants, err := ListVariants(ctx, m3u8url)
+	if err != nil {
+		return Variant{}, err
+	}
+	var pick *Variant
+	for i := range variants {
+		v := &variants[i]
+		if v.Width >= width && (pick == nil || v.Width < pick.Width) {
+			pick = v
+		}
+	}
+	if pick == nil {
+		// все варианты уже нужной ширины — берём лучший
+		return SelectVariant(variants, Quality{Mode: QualityBest})
+	}
+	log.Printf("🎞️ Для анимации шириной %d выбран вариант %s", width, pick.Label())
+	return *pick, nil
+}
@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// AudioFormat — формат режима «только звук»
+type AudioFormat string
+
+const (
+	AudioNone AudioFormat = ""    // видео целиком
+	AudioM4A  AudioFormat = "m4a" // AAC как есть, без перекодирования
+	AudioMP3  AudioFormat = "mp3" // перекодирование в MP3
+)
+
+// Битрейт MP3, кбит/с
+const (
+	defaultMP3Bitrate = 192
+	minMP3Bitrate     = 32
+	maxMP3Bitrate     = 320
+)
+
+// ParseAudioFormat разбирает формат из формы; "", "video" и "mp4" — видео
+func ParseAudioFormat(s string) (AudioFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "video", "mp4":
+		return AudioNone, nil
+	case "m4a", "aac":
+		return AudioM4A, nil
+	case "mp3":
+		return AudioMP3, nil
+	}
+	return AudioNone, fmt.Errorf("неизвестный формат %q", s)
+}
+
+// ParseAudioBitrate — "128", "128k"; пусто — по умолчанию (0)
+func ParseAudioBitrate(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "k")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < minMP3Bitrate || n > maxMP3Bitrate {
+		return 0, fmt.Errorf("битрейт должен быть от %d до %d кбит/с", minMP3Bitrate, maxMP3Bitrate)
+	}
+	return n, nil
+}
+
+func (o Options) mp3Bitrate() int {
+	if o.AudioBitrate > 0 {
+		return o.AudioBitrate
+	}
+	return defaultMP3Bitrate
+}
+
+// codecArgs — аргументы ffmpeg для выходного потока
+func (o Options) codecArgs() []string {
+	if o.Anim.Format != AnimNone {
+		// анимация всегда перекодируется — обрезка по фрагменту точная
+		return o.Anim.codecArgs()
+	}
+	switch o.Audio {
+	case AudioM4A:
+		return []string{"-vn", "-c:a", "copy"}
+	case AudioMP3:
+		return []string{"-vn", "-c:a", "libmp3lame", "-b:a", strconv.Itoa(o.mp3Bitrate()) + "k"}
+	}
+	if o.Preset != PresetNone {
+		// пресет и так перекодирует — обрезка получается точной без отдельных аргументов
+		return o.Preset.codecArgs()
+	}
+	if args, ok := o.containerArgs(); ok {
+		return args
+	}
+	if !o.Clip.IsZero() {
+		// copy режет только по ключевым кадрам — для точных границ видео перекодируем
+		return []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-c:a", "aac"}
+	}
+	return []string{"-c", "copy"}
+}
+
+// pickSource — что качать: вариант под качество или, в режиме звука и анимации, самый лёгкий подходящий источник
+func (o Options) pickSource(ctx context.Context, m3u8url string) (Variant, error) {
+	if o.Anim.Format != AnimNone {
+		return pickAnimSource(ctx, m3u8url, o.Anim.width())
+	}
+	if o.Audio == AudioNone {
+		return pickVariant(ctx, m3u8url, o.Preset.capQuality(o.Quality))
+	}
+	return pickAudioSource(ctx, m3u8url)
+}
+
+// pickAudioSource берёт отдельную звуковую дорожку (EXT-X-MEDIA TYPE=AUDIO), если мастер её даёт,
+// иначе вариант с наименьшим битрейтом — звук в нём тот же, а видео качать меньше
+func pickAudioSource(ctx context.Context, m3u8url string) (Variant, error) {
+	data, err := fetchPlaylistBytes(ctx, m3u8url)
+	if err != nil {
+		return Variant{}, err
+	}
+	mpl, err := tryDecodeMaster(data)
+	if err != nil || len(mpl.Variants) == 0 {
+		// media-плейлист — единственный источник
+		return Variant{URI: m3u8url}, nil
+	}
+
+	for _, v := range mpl.Variants {
+		if v == nil {
+			continue
+		}
+		for _, alt := range v.Alternatives {
+			if alt != nil && strings.EqualFold(alt.Type, "AUDIO") && alt.URI != "" {
+				log.Printf("🎧 Выбрана звуковая дорожка %q", alt.Name)
+				return Variant{URI: resolveURL(m3u8url, alt.URI)}, nil
+			}
+		}
+	}
+
+	v, err := SelectVariant(variantsFromMaster(m3u8url, mpl), Quality{Mode: QualityWorst})
+	if err != nil {
+		return Variant{}, err
+	}
+	log.Printf("🎧 Звук из варианта %s (%d bps)", v.Label(), v.Bandwidth)
+	return v, nil
+}
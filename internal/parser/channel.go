@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ChannelFilter — какие ролики канала качать; нулевые поля не ограничивают
+type ChannelFilter struct {
+	Since       time.Time     `json:"since,omitempty"`     // опубликованы не раньше
+	MaxCount    int           `json:"max_count,omitempty"` // не больше стольких самых новых
+	TitleRegex  string        `json:"title_regex,omitempty"`
+	MinDuration time.Duration `json:"min_duration,omitempty"`
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+}
+
+// Validate проверяет фильтр до постановки задачи
+func (f ChannelFilter) Validate() error {
+	if f.MaxCount < 0 {
+		return errors.New("количество видео не может быть отрицательным")
+	}
+	if f.MinDuration < 0 || f.MaxDuration < 0 {
+		return errors.New("длительность не может быть отрицательной")
+	}
+	if f.MaxDuration > 0 && f.MinDuration > f.MaxDuration {
+		return errors.New("минимальная длительность больше максимальной")
+	}
+	if _, err := f.titleRe(); err != nil {
+		return fmt.Errorf("регулярное выражение для названия: %w", err)
+	}
+	return nil
+}
+
+// titleRe — регулярка для названия без учёта регистра; nil, если не задана
+func (f ChannelFilter) titleRe() (*regexp.Regexp, error) {
+	if f.TitleRegex == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + f.TitleRegex)
+}
+
+// match — проходит ли ролик фильтры, кроме MaxCount и Since (их проверяет обход страниц)
+func (f ChannelFilter) match(v ListedVideo, re *regexp.Regexp) bool {
+	if re != nil && !re.MatchString(v.Title) {
+		return false
+	}
+	// длительность неизвестна — по ней не отсекаем
+	d := time.Duration(v.Duration * float64(time.Second))
+	if v.Duration > 0 {
+		if f.MinDuration > 0 && d < f.MinDuration {
+			return false
+		}
+		if f.MaxDuration > 0 && d > f.MaxDuration {
+			return false
+		}
+	}
+	return true
+}
+
+var reChannelPath = regexp.MustCompile(`(?i)^/channel/(\d+)(?:/.*)?$`)
+
+// ParseChannelURL — ID канала из ссылки вида rutube.ru/channel/<id>/ (в том числе /videos/)
+func ParseChannelURL(input string) (string, error) {
+	u, err := parseRutubeURL(input)
+	if err != nil {
+		return "", err
+	}
+	m := reChannelPath.FindStringSubmatch(u.Path)
+	if len(m) < 2 {
+		return "", errors.New("не смог распознать канал")
+	}
+	return m[1], nil
+}
+
+// GetChannel перебирает видео канала страницами API и отбирает подходящие под фильтр.
+// API отдаёт ролики от новых к старым, поэтому на первом ролике старше Since обход заканчивается.
+func GetChannel(ctx context.Context, channelID string, f ChannelFilter) (*Playlist, error) {
+	re, err := f.titleRe()
+	if err != nil {
+		return nil, err
+	}
+	pl := &Playlist{ID: channelID}
+
+	// название канала не критично
+	var meta struct {
+		Name string `json:"name"`
+	}
+	if err := getJSON(ctx, "https://rutube.ru/api/profile/user/"+channelID+"/", &meta); err == nil {
+		pl.Title = meta.Name
+	}
+
+	first := "https://rutube.ru/api/video/person/" + channelID + "/?page=1"
+	err = fetchPaged(ctx, first, func(raw json.RawMessage) error {
+		v, err := decodeListedVideo(raw)
+		if err != nil || v.ID == "" {
+			return err
+		}
+		if !f.Since.IsZero() && !v.PublishedAt.IsZero() && v.PublishedAt.Before(f.Since) {
+			return errStopPaging
+		}
+		if !f.match(v, re) {
+			return nil
+		}
+		pl.Videos = append(pl.Videos, v)
+		if f.MaxCount > 0 && len(pl.Videos) >= f.MaxCount {
+			return errStopPaging
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pl.Videos) == 0 {
+		return nil, ErrEmptyList
+	}
+	return pl, nil
+}
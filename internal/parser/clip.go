@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clip — фрагмент ролика; нулевые поля — с начала и до конца
+type Clip struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// IsZero — фрагмент не задан, качаем ролик целиком
+func (c Clip) IsZero() bool {
+	return c.Start == 0 && c.End == 0
+}
+
+// String — "1m0s-3m0s", "1m0s-" для «до конца»; пусто, если фрагмента нет
+func (c Clip) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	s := c.Start.String() + "-"
+	if c.End > 0 {
+		s += c.End.String()
+	}
+	return s
+}
+
+// ParseClip разбирает начало и конец фрагмента ("90", "1:30", "01:02:03.5"); пустое — без ограничения
+func ParseClip(start, end string) (Clip, error) {
+	var c Clip
+	var err error
+	if c.Start, err = ParseTimestamp(start); err != nil {
+		return Clip{}, fmt.Errorf("начало: %w", err)
+	}
+	if c.End, err = ParseTimestamp(end); err != nil {
+		return Clip{}, fmt.Errorf("конец: %w", err)
+	}
+	if c.End > 0 && c.End <= c.Start {
+		return Clip{}, errors.New("конец фрагмента должен быть позже начала")
+	}
+	return c, nil
+}
+
+// maxTimestamp — дальше этого ролики не бывают; защищает от переполнения time.Duration
+const maxTimestamp = 1000 * time.Hour
+
+// ParseTimestamp — секунды, мм:сс или чч:мм:сс, секунды могут быть дробными
+func ParseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("неверное время %q", s)
+	}
+	var total float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		// ParseFloat понимает и "NaN", "Inf", "1e30" — таких отметок не бывает
+		if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("неверное время %q", s)
+		}
+		// минуты и секунды перед последним полем — не больше 59
+		if i > 0 && v >= 60 {
+			return 0, fmt.Errorf("неверное время %q", s)
+		}
+		if i < len(parts)-1 && v != float64(int(v)) {
+			return 0, fmt.Errorf("неверное время %q", s)
+		}
+		total = total*60 + v
+	}
+	if total > maxTimestamp.Seconds() {
+		return 0, fmt.Errorf("слишком большое время %q", s)
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// clipSegments оставляет сегменты, перекрывающие фрагмент, по длительностям из плейлиста.
+// offset — где внутри первого оставленного сегмента начинается фрагмент, span — длительность оставленного.
+func clipSegments(segs []hlsSegment, c Clip) (out []hlsSegment, offset, span float64, err error) {
+	start := c.Start.Seconds()
+	end := c.End.Seconds()
+
+	var pos float64
+	var lastMap hlsSegment
+	for _, seg := range segs {
+		if seg.mapURL != "" {
+			lastMap = seg
+		}
+		segStart, segEnd := pos, pos+seg.duration
+		pos = segEnd
+		if segEnd <= start {
+			continue
+		}
+		if end > 0 && segStart >= end {
+			break
+		}
+		if len(out) == 0 {
+			offset = start - segStart
+			// init-секция пишется только при смене — первому сегменту фрагмента она нужна всегда
+			if seg.mapURL == "" && lastMap.mapURL != "" {
+				seg.mapURL, seg.mapLimit, seg.mapOff = lastMap.mapURL, lastMap.mapLimit, lastMap.mapOff
+			}
+		}
+		out = append(out, seg)
+		span += seg.duration
+	}
+	if len(out) == 0 {
+		return nil, 0, 0, fmt.Errorf("начало фрагмента (%v) за концом видео (%.0f с)", c.Start, pos)
+	}
+	return out, offset, span, nil
+}
+
+// duration — длительность фрагмента в секундах для ролика длиной total
+func (c Clip) duration(total float64) float64 {
+	if c.End > 0 {
+		return (c.End - c.Start).Seconds()
+	}
+	if d := total - c.Start.Seconds(); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// trimArgs — точная обрезка склеенных сегментов: ffmpeg -ss/-t после входа
+func (c Clip) trimArgs(offset float64) []string {
+	args := []string{"-ss", strconv.FormatFloat(offset, 'f', 3, 64)}
+	if c.End > 0 {
+		args = append(args, "-t", strconv.FormatFloat((c.End-c.Start).Seconds(), 'f', 3, 64))
+	}
+	return args
+}
@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"90", 90 * time.Second, false},
+		{"1:30", 90 * time.Second, false},
+		{"01:02:03.5", time.Hour + 2*time.Minute + 3500*time.Millisecond, false},
+		{"1:60", 0, true},
+		{"1.5:00", 0, true},
+		{"-5", 0, true},
+		{"1:2:3:4", 0, true},
+		{"abc", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"-Inf", 0, true},
+		{"1e30", 0, true},
+		{"1e308:00:00", 0, true},
+		{"1000:00:01", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseClipRejectsInf(t *testing.T) {
+	if _, err := ParseClip("10", "Inf"); err == nil {
+		t.Error(`ParseClip("10", "Inf") must fail`)
+	}
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Container — контейнер итогового видео; пусто — по бэкенду (OutputExt)
+type Container string
+
+const (
+	ContainerAuto Container = ""
+	ContainerMP4  Container = "mp4"  // H.264/AAC как есть
+	ContainerMKV  Container = "mkv"  // все дорожки потока без перекодирования
+	ContainerTS   Container = "ts"   // склеенные сегменты, самый быстрый путь
+	ContainerWebM Container = "webm" // перекодирование в VP9/Opus
+)
+
+// ParseContainer разбирает контейнер из формы; пусто и "auto" — по бэкенду
+func ParseContainer(s string) (Container, error) {
+	switch c := Container(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "."))); c {
+	case "auto":
+		return ContainerAuto, nil
+	case ContainerAuto, ContainerMP4, ContainerMKV, ContainerTS, ContainerWebM:
+		return c, nil
+	}
+	return ContainerAuto, fmt.Errorf("неизвестный контейнер %q", s)
+}
+
+// Validate проверяет сочетание форматов до загрузки: контейнер, встраивание субтитров и обложки,
+// у анимации — свои правила. Кодеки самого ролика проверяет checkCodecs, когда вариант выбран.
+func (o Options) Validate() error {
+	if o.Anim.Format != AnimNone {
+		return o.validateAnim()
+	}
+	if o.Container != ContainerAuto {
+		if o.Audio != AudioNone {
+			// у звука контейнер задаёт сам формат: m4a или mp3
+			return errors.New("контейнер выбирается только для видео")
+		}
+		if o.Preset != PresetNone && o.Container != ContainerMP4 {
+			// пресеты собраны под плееры устройств: H.264/AAC с moov в начале — это MP4
+			return fmt.Errorf("пресет %s сохраняется только в MP4", o.Preset)
+		}
+	}
+	ext := o.Ext()
+	if !o.Clip.IsZero() && ext == ".ts" {
+		// склейка сегментов режет только по их границам — точный фрагмент делает ffmpeg
+		if _, err := ffmpegBinary(); err != nil {
+			return errors.New("фрагмент в TS вырезает ffmpeg, а на сервере его нет — выберите видео целиком")
+		}
+	}
+	if o.Subtitles == SubtitlesEmbed && (o.Audio != AudioNone || subtitleCodec(ext) == "") {
+		return fmt.Errorf("в %s субтитры не встроить — выберите MP4, MKV или WebM либо субтитры файлами", strings.TrimPrefix(ext, "."))
+	}
+	if o.Poster == PosterEmbed && !embedsPoster(ext) {
+		return fmt.Errorf("в %s обложку не вшить — только в MP4, M4A и MP3; сохраните её файлом", strings.TrimPrefix(ext, "."))
+	}
+	return nil
+}
+
+// copyCodecs — кодеки (по началу записи в CODECS), которые контейнер принимает без перекодирования.
+// MKV принимает всё, его здесь нет.
+var copyCodecs = map[string][]string{
+	".mp4":  {"avc1", "avc3", "hvc1", "hev1", "av01", "vp09", "mp4a", "ac-3", "ec-3", "opus", "flac"},
+	".m4a":  {"mp4a", "ac-3", "ec-3", "opus", "flac", "alac"},
+	".ts":   {"avc1", "avc3", "hvc1", "hev1", "mp4a", "ac-3", "ec-3"},
+	".webm": {"vp8", "vp09", "av01", "opus", "vorbis"},
+}
+
+// ErrCodecMismatch — кодеки выбранного варианта нельзя скопировать в запрошенный контейнер
+var ErrCodecMismatch = errors.New("кодек ролика не помещается в контейнер без перекодирования")
+
+// audioCodecs — какие записи CODECS относятся к звуку; остальные — видео
+var audioCodecs = []string{"mp4a", "ac-3", "ec-3", "opus", "vorbis", "flac", "alac"}
+
+// checkCodecs сверяет CODECS выбранного варианта с контейнером для дорожек, которые
+// копируются как есть: HEVC в TS или VP9 в MP4 без перекодирования дали бы битый файл
+func (o Options) checkCodecs(v Variant) error {
+	allowed, ok := copyCodecs[o.Ext()]
+	if !ok || v.Codecs == "" {
+		// MKV, перекодирование в GIF/MP3 или media-плейлист без master — сверять не с чем
+		return nil
+	}
+	codec := o.codecArgs()
+	for _, c := range strings.Split(v.Codecs, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		kind := "v"
+		if hasCodecPrefix(audioCodecs, c) {
+			kind = "a"
+		}
+		if c != "" && copies(codec, kind) && !hasCodecPrefix(allowed, c) {
+			return fmt.Errorf("%w: %s в %s — выберите MKV", ErrCodecMismatch, c, strings.TrimPrefix(o.Ext(), "."))
+		}
+	}
+	return nil
+}
+
+func hasCodecPrefix(list []string, c string) bool {
+	for _, p := range list {
+		if strings.HasPrefix(c, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// copies — codec оставляет дорожки вида kind ("v" или "a") как есть
+func copies(codec []string, kind string) bool {
+	copied := false
+	for i, a := range codec {
+		switch {
+		case a == "-"+kind+"n":
+			return false
+		case (a == "-c" || a == "-c:"+kind) && i+1 < len(codec):
+			copied = codec[i+1] == "copy"
+		}
+	}
+	return copied
+}
+
+// containerArgs — кодеки под контейнер; ok=false — подходят общие правила codecArgs
+func (o Options) containerArgs() (args []string, ok bool) {
+	switch o.Container {
+	case ContainerWebM:
+		// WebM не принимает H.264/AAC — только VP8/VP9/AV1 и Vorbis/Opus
+		return []string{"-c:v", "libvpx-vp9", "-crf", "32", "-b:v", "0", "-row-mt", "1",
+			"-deadline", "good", "-cpu-used", "4", "-c:a", "libopus", "-b:a", "128k"}, true
+	case ContainerMKV:
+		if o.Clip.IsZero() {
+			// без -map ffmpeg берёт по одной дорожке каждого типа
+			return []string{"-map", "0:v", "-map", "0:a?", "-map", "0:s?", "-c", "copy"}, true
+		}
+	}
+	return nil, false
+}
+
+// subtitleCodec — кодек мягких субтитров в контейнере; пусто — встроить нельзя
+func subtitleCodec(path string) string {
+	switch filepath.Ext(path) {
+	case ".mp4":
+		return "mov_text"
+	case ".mkv":
+		return "srt"
+	case ".webm":
+		return "webvtt"
+	}
+	return ""
+}
+
+// mimeTypes — типы готовых файлов; системные таблицы не знают .mkv, а .ts считают исходником TypeScript
+var mimeTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".ts":   "video/mp2t",
+	".webm": "video/webm",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".srt":  "application/x-subrip",
+	".vtt":  "text/vtt; charset=utf-8",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+	".zip":  "application/zip",
+}
+
+// MIMEType — Content-Type для файла из downloads; пусто — расширение не наше
+func MIMEType(name string) string {
+	return mimeTypes[strings.ToLower(filepath.Ext(name))]
+}
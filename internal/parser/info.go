@@ -0,0 +1,63 @@
+package parser
+
+import "context"
+
+// VideoInfo — метаданные ролика без скачивания
+type VideoInfo struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Duration  float64   `json:"duration"` // секунды, 0 если не удалось посчитать или это эфир
+	Thumbnail string    `json:"thumbnail"`
+	Author    string    `json:"author"`
+	Variants  []Variant `json:"variants"`
+
+	Subtitles []Subtitle `json:"subtitles,omitempty"`
+	Live      bool       `json:"live"`                 // прямой эфир: будет записан, а не скачан
+	DVRWindow float64    `json:"dvr_window,omitempty"` // секунды эфира, доступные с from_start
+}
+
+// GetInfo собирает метаданные: ID, заголовок, длительность, превью, автора, варианты качества и субтитры
+func GetInfo(ctx context.Context, videoURL string) (*VideoInfo, error) {
+	ref, err := ParseVideoURL(videoURL)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := fetchOptions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := ListVariants(ctx, opts.VideoBalancer.M3u8)
+	if err != nil {
+		return nil, err
+	}
+
+	// у эфира длительности нет — только текущее окно плейлиста
+	live, err := isLive(ctx, variants[0].URI)
+	if err != nil {
+		return nil, err
+	}
+
+	// длительность одинакова у всех вариантов — считаем по первому;
+	// у эфира та же сумма EXTINF — это DVR-окно, с которого можно начать запись
+	totalDur, err := totalDurationSeconds(ctx, variants[0].URI)
+	if err != nil {
+		// не критично — отдадим без длительности
+		totalDur = 0
+	}
+	var dvr float64
+	if live {
+		dvr, totalDur = totalDur, 0
+	}
+
+	return &VideoInfo{
+		ID:        ref.ID,
+		Title:     opts.Title,
+		Duration:  totalDur,
+		Thumbnail: opts.posterURL(),
+		Author:    opts.Author.Name,
+		Variants:  variants,
+		Subtitles: opts.subtitles(),
+		Live:      live,
+		DVRWindow: dvr,
+	}, nil
+}
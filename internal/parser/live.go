@@ -0,0 +1,257 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LiveOptions — запись прямого эфира
+type LiveOptions struct {
+	MaxDuration time.Duration   // 0 — пока эфир не закончится или запись не остановят
+	FromStart   bool            // начать с самого раннего сегмента DVR-окна, а не с края эфира
+	Stop        <-chan struct{} // закрытие — закончить запись и сохранить файл
+	// RequireLimit — без MaxDuration эфир не писать: видео плейлиста или канала иначе держало бы
+	// набор открытым до конца трансляции, а остановить запись со страницы набора нельзя
+	RequireLimit bool
+	// OnProgress — сколько уже записано: секунды эфира и байты
+	OnProgress func(elapsed time.Duration, bytes int64)
+}
+
+// ErrLiveNoLimit — ролик оказался эфиром, а писать его без лимита нельзя (LiveOptions.RequireLimit)
+var ErrLiveNoLimit = errors.New("прямой эфир без лимита длительности не записывается")
+
+// liveEdgeSegments — запись начинается с последних сегментов окна, как у плеера
+const liveEdgeSegments = 3
+
+// isLive — у media-плейлиста нет EXT-X-ENDLIST: это эфир, плейлист будет дописываться
+func isLive(ctx context.Context, m3u8url string) (bool, error) {
+	mp, err := fetchMediaPlaylist(ctx, m3u8url)
+	if err != nil {
+		return false, err
+	}
+	return !mp.Closed, nil
+}
+
+// liveState — докуда дошла запись; лежит в workDir и переживает повтор и перезапуск.
+// Size — сколько байт live.ts подтверждено: всё дальше — недописанный сегмент после сбоя.
+type liveState struct {
+	NextSeq uint64  `json:"next_seq"`
+	Elapsed float64 `json:"elapsed"`
+	Size    int64   `json:"size"`
+}
+
+func readLiveState(workDir string) (liveState, bool) {
+	var st liveState
+	b, err := os.ReadFile(filepath.Join(workDir, "live.json"))
+	if err != nil || json.Unmarshal(b, &st) != nil {
+		return liveState{}, false
+	}
+	return st, true
+}
+
+func writeLiveState(workDir string, st liveState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(workDir, "live.json")
+	if err := os.WriteFile(p+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// recordLive опрашивает плейлист эфира и дописывает новые сегменты в tsPath по порядку.
+// Заканчивает без ошибки, когда эфир завершился, истёк MaxDuration или закрыт Stop;
+// отмена ctx возвращает ошибку, записанное остаётся в workDir для продолжения.
+func recordLive(ctx context.Context, m3u8url, workDir, tsPath string, lo LiveOptions) error {
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tsPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, resumed := readLiveState(workDir)
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if resumed && info.Size() < st.Size {
+		// записи меньше, чем подтверждено, — продолжать нечего, начинаем заново
+		log.Printf("⚠️ Запись эфира в %s короче сохранённой — начинаем заново", workDir)
+		st, resumed = liveState{}, false
+	}
+	// сегмент, дописанный после последнего сохранения состояния, обрезаем — его скачаем снова
+	if err := f.Truncate(st.Size); err != nil {
+		return err
+	}
+	bytes := st.Size
+	if resumed {
+		log.Printf("♻️ Продолжаем запись эфира: уже %v", time.Duration(st.Elapsed*float64(time.Second)).Round(time.Second))
+	}
+
+	report := func() {
+		if lo.OnProgress != nil {
+			lo.OnProgress(time.Duration(st.Elapsed*float64(time.Second)), bytes)
+		}
+	}
+	report()
+
+	retries := IntFromEnv("HLS_SEGMENT_RETRIES", defaultRetries)
+	dec := newSegmentDecrypter()
+	writtenMap := ""
+	failures := 0
+
+	for {
+		mp, err := fetchMediaPlaylist(ctx, m3u8url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			if failures > retries {
+				return fmt.Errorf("плейлист эфира: %w", err)
+			}
+			log.Printf("⚠️ Плейлист эфира, попытка %d/%d: %v", failures, retries, err)
+		} else {
+			failures = 0
+			segs := collectSegments(m3u8url, mp)
+
+			if !resumed && len(segs) > 0 {
+				first := len(segs) - liveEdgeSegments
+				if first < 0 || lo.FromStart {
+					first = 0
+				}
+				if lo.FromStart {
+					log.Printf("⏪ Запись с начала DVR-окна: %d сегментов, %v", len(segs), time.Duration(windowSeconds(segs)*float64(time.Second)).Round(time.Second))
+				}
+				st.NextSeq = segs[first].seqNo
+				resumed = true
+			}
+			if len(segs) > 0 && segs[0].seqNo > st.NextSeq {
+				log.Printf("⚠️ Окно эфира ушло вперёд: пропущено %d сегментов", segs[0].seqNo-st.NextSeq)
+			}
+
+			var curMap hlsSegment
+			for _, seg := range segs {
+				if seg.mapURL != "" {
+					curMap = seg
+				}
+				if seg.seqNo < st.NextSeq {
+					continue
+				}
+				// init-секцию пишем только когда она сменилась относительно уже записанного
+				seg.mapURL, seg.mapLimit, seg.mapOff = "", 0, 0
+				if curMap.mapURL != "" && curMap.mapURL != writtenMap {
+					seg.mapURL, seg.mapLimit, seg.mapOff = curMap.mapURL, curMap.mapLimit, curMap.mapOff
+				}
+				// номер сегмента в эфире стабилен, в отличие от позиции в окне
+				seg.index = int(seg.seqNo)
+
+				n, err := appendLiveSegment(ctx, f, seg, workDir, dec, retries)
+				if err != nil {
+					return err
+				}
+				if seg.mapURL != "" {
+					writtenMap = seg.mapURL
+				}
+				bytes += n
+				st.Elapsed += seg.duration
+				st.NextSeq = seg.seqNo + 1
+				st.Size = bytes
+				if err := writeLiveState(workDir, st); err != nil {
+					return err
+				}
+				report()
+
+				if lo.MaxDuration > 0 && st.Elapsed >= lo.MaxDuration.Seconds() {
+					log.Printf("⏹️ Запись эфира: достигнут лимит %v", lo.MaxDuration)
+					return nil
+				}
+				if stopped(lo.Stop) {
+					log.Println("⏹️ Запись эфира остановлена")
+					return nil
+				}
+			}
+
+			if mp.Closed {
+				log.Println("⏹️ Эфир закончился")
+				return nil
+			}
+		}
+
+		// плейлист обновляется раз в сегмент — опрашиваем чаще, чтобы не отставать
+		wait := time.Second
+		if mp != nil && mp.TargetDuration > 2 {
+			wait = time.Duration(mp.TargetDuration / 2 * float64(time.Second))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lo.Stop:
+			log.Println("⏹️ Запись эфира остановлена")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// appendLiveSegment качает сегмент через общий загрузчик и дописывает его в конец записи.
+// Если запись оборвалась посередине, хвост обрежет следующий запуск recordLive по liveState.Size.
+func appendLiveSegment(ctx context.Context, f *os.File, seg hlsSegment, workDir string, dec *segmentDecrypter, retries int) (int64, error) {
+	if err := fetchSegmentWithRetry(ctx, seg, workDir, dec, retries); err != nil {
+		return 0, fmt.Errorf("сегмент %d: %w", seg.seqNo, err)
+	}
+	p := segmentPath(workDir, seg.index)
+	in, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, in)
+	in.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, os.Remove(p)
+}
+
+// windowSeconds — длительность окна плейлиста (для эфира — сколько доступно в DVR)
+func windowSeconds(segs []hlsSegment) float64 {
+	var sum float64
+	for _, s := range segs {
+		sum += s.duration
+	}
+	return sum
+}
+
+// stopped — неблокирующая проверка закрытого канала; nil — никогда
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordLiveTo записывает эфир и собирает итоговый файл так же, как mux для записи
+func recordLiveTo(ctx context.Context, m3u8url, outPath, workDir string, codec []string, lo LiveOptions) error {
+	tsPath := filepath.Join(workDir, "live.ts")
+	if err := recordLive(ctx, m3u8url, workDir, tsPath, lo); err != nil {
+		return err
+	}
+	if info, err := os.Stat(tsPath); err != nil || info.Size() == 0 {
+		return errors.New("эфир не прислал ни одного сегмента")
+	}
+	return finishOutput(ctx, tsPath, outPath, workDir, codec, 0, nil)
+}
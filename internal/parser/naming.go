@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultNameTemplate — имя файла по умолчанию; переопределяется FILENAME_TEMPLATE.
+// Поля: {title}, {id}, {job}, {author}, {height}, {ext}.
+const defaultNameTemplate = "{title} [{id}] {height}p.{ext}"
+
+// nameFields — значения для шаблона имени
+type nameFields struct {
+	Title  string
+	ID     string
+	JobID  string
+	Author string
+	Height int
+	Ext    string // без точки
+}
+
+func nameTemplate() string {
+	if t := strings.TrimSpace(os.Getenv("FILENAME_TEMPLATE")); t != "" {
+		return t
+	}
+	return defaultNameTemplate
+}
+
+// renderFileName подставляет поля в шаблон. Имя всегда содержит ID видео или задачи,
+// чтобы ролики с одинаковыми заголовками не перезаписывали друг друга.
+func renderFileName(tmpl string, f nameFields) string {
+	if !strings.Contains(tmpl, "{id}") && !strings.Contains(tmpl, "{job}") {
+		log.Printf("⚠️ В шаблоне имени %q нет {id} и {job} — добавляем [{id}]", tmpl)
+		tmpl = strings.TrimSuffix(tmpl, ".{ext}") + " [{id}].{ext}"
+	}
+	if !strings.Contains(tmpl, "{ext}") {
+		tmpl += ".{ext}"
+	}
+
+	// неизвестное разрешение (media-плейлист без master) — убираем вместе с суффиксом "p"
+	height := ""
+	if f.Height > 0 {
+		height = strconv.Itoa(f.Height)
+	} else {
+		tmpl = strings.ReplaceAll(tmpl, "{height}p", "")
+	}
+
+	author := ""
+	if f.Author != "" {
+		author = sanitize(f.Author)
+	}
+
+	name := strings.NewReplacer(
+		"{title}", sanitize(f.Title),
+		"{id}", f.ID,
+		"{job}", f.JobID,
+		"{author}", author,
+		"{height}", height,
+		"{ext}", f.Ext,
+	).Replace(tmpl)
+
+	// после пустых полей могут остаться двойные пробелы и пустые скобки
+	name = strings.ReplaceAll(name, "[]", "")
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.ReplaceAll(name, " .", ".")
+	return sanitizeFileName(name)
+}
+
+// sanitizeFileName — как sanitize, но без обрезки: длину заголовка уже ограничил sanitize
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 32 || r == 127:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.TrimLeft(strings.TrimSpace(s), ".")
+}
+
+// tagFileName дописывает [tag] перед расширением: "Видео [id].mp4" → "Видео [id] [tag].mp4"
+func tagFileName(name, tag string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + " [" + sanitizeFileName(tag) + "]" + ext
+}
+
+// ArchiveName — имя ZIP-архива для набора роликов (плейлист, канал)
+func ArchiveName(title, id string) string {
+	name := id
+	if title = strings.TrimSpace(title); title != "" {
+		name = sanitize(title) + " [" + id + "]"
+	}
+	return sanitizeFileName(name + ".zip")
+}
@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// maxListPages — предохранитель от бесконечной пагинации
+const maxListPages = 500
+
+// ListedVideo — ролик из списка (плейлист, канал)
+type ListedVideo struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Duration    float64   `json:"duration,omitempty"` // секунды, 0 — неизвестна
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Playlist — набор роликов: плейлист RuTube или отобранные видео канала
+type Playlist struct {
+	ID     string        `json:"id"`
+	Title  string        `json:"title"`
+	Videos []ListedVideo `json:"videos"`
+}
+
+// ErrEmptyList — в наборе не нашлось ни одного ролика для загрузки
+var ErrEmptyList = errors.New("нет видео для загрузки")
+
+// pagedResponse — общий формат постраничных списков API RuTube
+type pagedResponse struct {
+	HasNext bool              `json:"has_next"`
+	Next    string            `json:"next"`
+	Results []json.RawMessage `json:"results"`
+}
+
+var rePlaylistPath = regexp.MustCompile(`(?i)^/(?:plst|playlist|playlists)/(\d+)/?$`)
+
+// ParsePlaylistURL — ID плейлиста из ссылки вида rutube.ru/plst/<id>/
+func ParsePlaylistURL(input string) (string, error) {
+	u, err := parseRutubeURL(input)
+	if err != nil {
+		return "", err
+	}
+	m := rePlaylistPath.FindStringSubmatch(u.Path)
+	if len(m) < 2 {
+		return "", errors.New("не смог распознать плейлист")
+	}
+	return m[1], nil
+}
+
+// GetPlaylist собирает все ролики плейлиста, проходя по страницам API
+func GetPlaylist(ctx context.Context, playlistID string) (*Playlist, error) {
+	pl := &Playlist{ID: playlistID}
+
+	// заголовок не критичен
+	var meta struct {
+		Title string `json:"title"`
+	}
+	if err := getJSON(ctx, "https://rutube.ru/api/playlist/custom/"+playlistID+"/", &meta); err == nil {
+		pl.Title = meta.Title
+	}
+
+	first := "https://rutube.ru/api/playlist/custom/" + playlistID + "/videos/?page=1"
+	err := fetchPaged(ctx, first, func(raw json.RawMessage) error {
+		v, err := decodeListedVideo(raw)
+		if err != nil || v.ID == "" {
+			return err
+		}
+		pl.Videos = append(pl.Videos, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pl.Videos) == 0 {
+		return nil, ErrEmptyList
+	}
+	return pl, nil
+}
+
+// decodeListedVideo — элемент списка API в ListedVideo
+func decodeListedVideo(raw json.RawMessage) (ListedVideo, error) {
+	var v struct {
+		ID            string  `json:"id"`
+		Title         string  `json:"title"`
+		VideoURL      string  `json:"video_url"`
+		Duration      float64 `json:"duration"`
+		PublicationTS string  `json:"publication_ts"`
+		CreatedTS     string  `json:"created_ts"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return ListedVideo{}, err
+	}
+	if v.VideoURL == "" && v.ID != "" {
+		v.VideoURL = "https://rutube.ru/video/" + v.ID + "/"
+	}
+	published := parseAPITime(v.PublicationTS)
+	if published.IsZero() {
+		published = parseAPITime(v.CreatedTS)
+	}
+	return ListedVideo{
+		ID:          v.ID,
+		Title:       v.Title,
+		URL:         v.VideoURL,
+		Duration:    v.Duration,
+		PublishedAt: published,
+	}, nil
+}
+
+// parseAPITime — даты API приходят то с зоной, то без (тогда это московское время)
+func parseAPITime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	msk := time.FixedZone("MSK", 3*60*60)
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", s, msk); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// fetchPaged проходит по страницам списка, пока API сообщает has_next.
+// visit вызывается на каждый элемент; ошибка visit или errStopPaging прекращает обход.
+func fetchPaged(ctx context.Context, firstURL string, visit func(json.RawMessage) error) error {
+	next := firstURL
+	for page := 1; next != "" && page <= maxListPages; page++ {
+		var resp pagedResponse
+		if err := getJSON(ctx, next, &resp); err != nil {
+			return fmt.Errorf("страница %d: %w", page, err)
+		}
+		for _, raw := range resp.Results {
+			if err := visit(raw); err != nil {
+				if errors.Is(err, errStopPaging) {
+					return nil
+				}
+				return err
+			}
+		}
+		if !resp.HasNext {
+			break
+		}
+		next = resp.Next
+		if next == "" {
+			next = nextPageURL(firstURL, page+1)
+		}
+	}
+	return nil
+}
+
+// errStopPaging — visit больше не нужны элементы
+var errStopPaging = errors.New("stop paging")
+
+// nextPageURL — если API не прислал next, подставляем page=N сами
+func nextPageURL(first string, page int) string {
+	u, err := url.Parse(first)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("page", fmt.Sprint(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// getJSON — GET с браузерными заголовками и разбор JSON
+func getJSON(ctx context.Context, u string, v any) error {
+	resp, err := httpGetWithHeaders(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpStatusError("api", resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PosterMode — что делать с обложкой ролика
+type PosterMode string
+
+const (
+	PosterNone  PosterMode = ""      // не качать
+	PosterFile  PosterMode = "file"  // картинка рядом с видео
+	PosterEmbed PosterMode = "embed" // картинка + обложка внутри MP4/M4A/MP3
+)
+
+// ParsePosterMode разбирает режим из формы; пусто и "none" — без обложки
+func ParsePosterMode(s string) (PosterMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none":
+		return PosterNone, nil
+	case "file":
+		return PosterFile, nil
+	case "embed":
+		return PosterEmbed, nil
+	}
+	return PosterNone, fmt.Errorf("неизвестный режим обложки %q", s)
+}
+
+// posterURL — превью ролика: thumbnail_url, иначе запасные поля API
+func (po *playOptions) posterURL() string {
+	for _, u := range []string{po.ThumbnailURL, po.PictureURL, po.PreviewURL} {
+		if u = strings.TrimSpace(u); u != "" {
+			return resolveURL(defaultRef, u)
+		}
+	}
+	return ""
+}
+
+// savePoster качает обложку и сохраняет рядом с видео с расширением по содержимому
+func savePoster(ctx context.Context, posterURL, videoPath string) (string, error) {
+	if posterURL == "" {
+		return "", errors.New("у ролика нет обложки")
+	}
+	data, err := fetchBytes(ctx, posterURL, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	ext := imageExt(data)
+	if ext == "" {
+		return "", errors.New("обложка не похожа на картинку")
+	}
+	path := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ext
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// imageExt — расширение по сигнатуре; пусто, если формат не узнан
+func imageExt(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return ".jpg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return ".webp"
+	}
+	return ""
+}
+
+// embedsPoster — контейнеры, в которых ffmpeg умеет обложку (attached_pic)
+func embedsPoster(ext string) bool {
+	switch ext {
+	case ".mp4", ".m4a", ".mp3":
+		return true
+	}
+	return false
+}
+
+// canEmbedPoster — обложку можно вшить в этот файл; WebP в контейнеры не кладём
+func canEmbedPoster(mediaPath, posterPath string) bool {
+	return filepath.Ext(posterPath) != ".webp" && embedsPoster(filepath.Ext(mediaPath))
+}
+
+// embedPoster вшивает картинку как обложку без перекодирования остальных дорожек
+func embedPoster(ctx context.Context, mediaPath, posterPath string) error {
+	ffmpegPath, err := ffmpegBinary()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(mediaPath)
+	tmp := strings.TrimSuffix(mediaPath, ext) + ".part" + ext
+
+	args := []string{"-y", "-i", mediaPath, "-i", posterPath, "-map", "0", "-map", "1", "-c", "copy"}
+	if ext == ".mp3" {
+		// в MP3 обложка живёт в ID3v2
+		args = append(args, "-id3v2_version", "3")
+	}
+	// картинка — последняя видеодорожка
+	args = append(args, "-disposition:v:"+videoStreamIndex(ext), "attached_pic", tmp)
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, mediaPath)
+}
+
+// videoStreamIndex — номер картинки среди видеодорожек выхода: в звуке она единственная
+func videoStreamIndex(ext string) string {
+	if ext == ".m4a" || ext == ".mp3" {
+		return "0"
+	}
+	return "1"
+}
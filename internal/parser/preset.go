@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Preset — профиль перекодирования под устройство; пусто — поток копируется как есть
+type Preset string
+
+const (
+	PresetNone   Preset = ""
+	PresetIPhone Preset = "iphone-h264-720p" // H.264 High@4.0 до 720p, AAC стерео — iPhone и iPad
+	PresetTV     Preset = "tv-compat"        // H.264 Main@4.0 до 1080p, 30 к/с — старые Smart TV
+	PresetSmall  Preset = "small-480p"       // H.264 Main до 480p, низкий битрейт — телефон и слабая сеть
+)
+
+// presetProfile — параметры кодирования пресета
+type presetProfile struct {
+	maxHeight int      // выше не качаем и не кодируем
+	video     []string // -c:v и всё, что к нему относится
+	audio     []string // -c:a ...
+}
+
+var presetProfiles = map[Preset]presetProfile{
+	PresetIPhone: {
+		maxHeight: 720,
+		video: []string{"-c:v", "libx264", "-profile:v", "high", "-level", "4.0", "-pix_fmt", "yuv420p",
+			"-preset", "veryfast", "-crf", "21", "-maxrate", "3M", "-bufsize", "6M"},
+		audio: []string{"-c:a", "aac", "-b:a", "128k", "-ac", "2"},
+	},
+	PresetTV: {
+		maxHeight: 1080,
+		video: []string{"-c:v", "libx264", "-profile:v", "main", "-level", "4.0", "-pix_fmt", "yuv420p",
+			"-preset", "veryfast", "-crf", "20", "-maxrate", "8M", "-bufsize", "16M", "-r", "30", "-g", "60"},
+		audio: []string{"-c:a", "aac", "-b:a", "160k", "-ac", "2", "-ar", "48000"},
+	},
+	PresetSmall: {
+		maxHeight: 480,
+		video: []string{"-c:v", "libx264", "-profile:v", "main", "-level", "3.1", "-pix_fmt", "yuv420p",
+			"-preset", "veryfast", "-crf", "26", "-maxrate", "1M", "-bufsize", "2M"},
+		audio: []string{"-c:a", "aac", "-b:a", "96k", "-ac", "2"},
+	},
+}
+
+// ParsePreset разбирает пресет из формы; пусто и "none" — без перекодирования
+func ParsePreset(s string) (Preset, error) {
+	p := Preset(strings.ToLower(strings.TrimSpace(s)))
+	if p == "none" {
+		return PresetNone, nil
+	}
+	if _, ok := presetProfiles[p]; !ok && p != PresetNone {
+		return PresetNone, fmt.Errorf("неизвестный пресет %q", s)
+	}
+	return p, nil
+}
+
+// capQuality — качать вариант выше потолка пресета незачем, всё равно уменьшим
+func (p Preset) capQuality(q Quality) Quality {
+	prof, ok := presetProfiles[p]
+	if !ok {
+		return q
+	}
+	if q.Mode == QualityWorst || (q.Mode != QualityBest && q.Height <= prof.maxHeight) {
+		return q
+	}
+	return Quality{Mode: QualityAtMost, Height: prof.maxHeight}
+}
+
+// codecArgs — аргументы ffmpeg пресета; меньшие ролики не растягиваются
+func (p Preset) codecArgs() []string {
+	prof := presetProfiles[p]
+	h := strconv.Itoa(prof.maxHeight)
+	args := []string{"-vf", "scale=-2:'min(" + h + ",ih)'"}
+	args = append(args, prof.video...)
+	args = append(args, prof.audio...)
+	// moov в начале — телефон и телевизор начинают играть, не дочитав файл
+	return append(args, "-movflags", "+faststart")
+}
+
+// reencodes — codec перекодирует видео (пресет, точная обрезка): это долго, и прогресс нужен от ffmpeg
+func reencodes(codec []string) bool {
+	for i := 0; i+1 < len(codec); i++ {
+		if codec[i] == "-c:v" && codec[i+1] != "copy" {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeShare — доля прогресса, которую занимает перекодирование после загрузки сегментов
+const encodeShare = 0.7
+
+// splitProgress делит один прогресс на загрузку и перекодирование, чтобы процент не откатывался к нулю
+func splitProgress(onProgress func(done, total float64)) (download, encode func(done, total float64)) {
+	download = func(done, total float64) {
+		onProgress(done*(1-encodeShare), total)
+	}
+	encode = func(done, total float64) {
+		onProgress(total*(1-encodeShare)+done*encodeShare, total)
+	}
+	return download, encode
+}
@@ -0,0 +1,334 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// Variant — один вариант (качество) из master-плейлиста
+type Variant struct {
+	URI        string `json:"uri"`
+	Bandwidth  uint32 `json:"bandwidth"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Resolution string `json:"resolution"`
+	Codecs     string `json:"codecs"`
+}
+
+// Label — человекочитаемое качество: "720p" или "1500 kbps", если разрешения нет
+func (v Variant) Label() string {
+	if v.Height > 0 {
+		return strconv.Itoa(v.Height) + "p"
+	}
+	return fmt.Sprintf("%d kbps", v.Bandwidth/1000)
+}
+
+// QualityMode — как выбирать вариант
+type QualityMode int
+
+const (
+	QualityBest   QualityMode = iota // самый высокий битрейт
+	QualityWorst                     // самый низкий битрейт
+	QualityExact                     // ровно NNNp, иначе ближайший
+	QualityAtMost                    // не выше NNNp
+)
+
+// Quality — разобранный запрос качества ("best", "worst", "720p", "<=720p")
+type Quality struct {
+	Mode   QualityMode
+	Height int
+}
+
+func (q Quality) String() string {
+	switch q.Mode {
+	case QualityWorst:
+		return "worst"
+	case QualityExact:
+		return strconv.Itoa(q.Height) + "p"
+	case QualityAtMost:
+		return "<=" + strconv.Itoa(q.Height) + "p"
+	default:
+		return "best"
+	}
+}
+
+// ParseQuality разбирает строку качества; пустая строка — "best"
+func ParseQuality(s string) (Quality, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "best":
+		return Quality{Mode: QualityBest}, nil
+	case "worst":
+		return Quality{Mode: QualityWorst}, nil
+	}
+
+	mode := QualityExact
+	if strings.HasPrefix(s, "<=") {
+		mode = QualityAtMost
+		s = strings.TrimSpace(strings.TrimPrefix(s, "<="))
+	}
+	h, err := strconv.Atoi(strings.TrimSuffix(s, "p"))
+	if err != nil || h <= 0 {
+		return Quality{}, fmt.Errorf("неизвестное качество %q", s)
+	}
+	return Quality{Mode: mode, Height: h}, nil
+}
+
+// Options — параметры загрузки, которые выбирает пользователь
+type Options struct {
+	Quality Quality
+	WorkDir string // чекпоинты сегментов; пусто — work/<id видео>
+	JobID   string // для {job} в шаблоне имени файла
+
+	Audio        AudioFormat // только звук; пусто — видео
+	AudioBitrate int         // кбит/с для mp3; 0 — по умолчанию
+
+	Clip Clip // только фрагмент; нулевой — ролик целиком
+
+	Preset    Preset    // перекодировать под устройство; пусто — копировать поток
+	Container Container // mp4/mkv/ts/webm; пусто — по бэкенду
+
+	Anim AnimOptions // GIF или зацикленное MP4 из фрагмента Clip вместо видео
+
+	Subtitles SubtitleMode // скачать субтитры и, при embed, встроить в MP4
+	Poster    PosterMode   // сохранить обложку и, при embed, вшить в файл
+
+	Live LiveOptions // если ролик окажется эфиром
+}
+
+// Ext — расширение итогового файла: аудиоформат, выбранный контейнер или контейнер бэкенда
+func (o Options) Ext() string {
+	if o.Anim.Format != AnimNone {
+		return o.Anim.ext()
+	}
+	if o.Audio != AudioNone {
+		return "." + string(o.Audio)
+	}
+	if o.Container != ContainerAuto {
+		return "." + string(o.Container)
+	}
+	if o.Preset != PresetNone {
+		// пресеты — для плееров устройств, им нужен MP4
+		return ".mp4"
+	}
+	return OutputExt()
+}
+
+// FormatKey — формат результата для сравнения задач: "mp4", "m4a", "mp3@192k", "mp4:tv-compat", "gif@480w12fps"
+func (o Options) FormatKey() string {
+	if o.Anim.Format != AnimNone {
+		return fmt.Sprintf("%s@%dw%dfps", o.Anim.Format, o.Anim.width(), o.Anim.fps())
+	}
+	key := strings.TrimPrefix(o.Ext(), ".")
+	if o.Audio == AudioMP3 {
+		key += fmt.Sprintf("@%dk", o.mp3Bitrate())
+	}
+	if o.Audio == AudioNone && o.Preset != PresetNone {
+		key += ":" + string(o.Preset)
+	}
+	return key
+}
+
+// fileName — имя итогового файла по шаблону FILENAME_TEMPLATE
+func (o Options) fileName(id string, po *playOptions, v Variant) string {
+	height := v.Height
+	if o.Audio != AudioNone {
+		// у звуковой дорожки разрешения нет
+		height = 0
+	}
+	name := renderFileName(nameTemplate(), nameFields{
+		Title:  po.Title,
+		ID:     id,
+		JobID:  o.JobID,
+		Author: po.Author.Name,
+		Height: height,
+		Ext:    strings.TrimPrefix(o.Ext(), "."),
+	})
+	if !o.Clip.IsZero() {
+		// фрагменты одного ролика не должны перезаписывать друг друга и целое видео
+		name = tagFileName(name, o.Clip.String())
+	}
+	if o.Audio == AudioNone && o.Preset != PresetNone {
+		name = tagFileName(name, string(o.Preset))
+	}
+	if o.Anim.Format != AnimNone {
+		name = tagFileName(name, o.Anim.String())
+	}
+	// встраивание переписывает файл — у задач со встраиванием и без него файлы должны быть разными
+	if o.Subtitles == SubtitlesEmbed {
+		name = tagFileName(name, "subs")
+	}
+	if o.Poster == PosterEmbed {
+		name = tagFileName(name, "cover")
+	}
+	return name
+}
+
+func (o Options) workDir(id string) string {
+	if o.WorkDir != "" {
+		return o.WorkDir
+	}
+	return defaultWorkDir(id)
+}
+
+// ListVariants скачивает плейлист и возвращает все варианты master-плейлиста.
+// Для media-плейлиста возвращается один вариант с исходным URL.
+func ListVariants(ctx context.Context, m3u8url string) ([]Variant, error) {
+	data, err := fetchPlaylistBytes(ctx, m3u8url)
+	if err != nil {
+		return nil, err
+	}
+
+	if mpl, err := tryDecodeMaster(data); err == nil && len(mpl.Variants) > 0 {
+		variants := variantsFromMaster(m3u8url, mpl)
+		if len(variants) == 0 {
+			// в мастере только I-frame плейлисты — смотреть нечего
+			return nil, errors.New("в плейлисте нет вариантов для просмотра")
+		}
+		return variants, nil
+	}
+
+	// возможно, это media — ок, единственный вариант без параметров
+	if _, err := tryDecodeMedia(data); err == nil {
+		return []Variant{{URI: m3u8url}}, nil
+	}
+
+	// ни master, ни media — странно, вернём кусок плейлиста для отладки
+	sample := string(data)
+	if len(sample) > 200 {
+		sample = sample[:200]
+	}
+	return nil, fmt.Errorf("не удалось распарсить плейлист (ни master, ни media). фрагмент: %q", sample)
+}
+
+// fetchPlaylistBytes читает плейлист в буфер, чтобы можно было пробовать и master, и media
+func fetchPlaylistBytes(ctx context.Context, m3u8url string) ([]byte, error) {
+	resp, err := httpGetWithHeaders(ctx, m3u8url)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки m3u8: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return nil, fmt.Errorf("m3u8 http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func variantsFromMaster(masterURL string, mpl *m3u8.MasterPlaylist) []Variant {
+	out := make([]Variant, 0, len(mpl.Variants))
+	for _, v := range mpl.Variants {
+		if v == nil || v.Iframe {
+			continue
+		}
+		w, h := parseResolution(v.Resolution)
+		out = append(out, Variant{
+			URI:        resolveURL(masterURL, v.URI),
+			Bandwidth:  v.Bandwidth,
+			Width:      w,
+			Height:     h,
+			Resolution: v.Resolution,
+			Codecs:     v.Codecs,
+		})
+	}
+	// от лучшего к худшему
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Height != out[j].Height {
+			return out[i].Height > out[j].Height
+		}
+		return out[i].Bandwidth > out[j].Bandwidth
+	})
+	return out
+}
+
+// "1280x720" → 1280, 720
+func parseResolution(s string) (int, int) {
+	wStr, hStr, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "x")
+	if !ok {
+		return 0, 0
+	}
+	w, err1 := strconv.Atoi(wStr)
+	h, err2 := strconv.Atoi(hStr)
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+// SelectVariant выбирает вариант под запрошенное качество.
+// Если точного совпадения нет — берём ближайший по высоте.
+func SelectVariant(variants []Variant, q Quality) (Variant, error) {
+	if len(variants) == 0 {
+		return Variant{}, errors.New("в плейлисте нет вариантов")
+	}
+
+	byBandwidth := append([]Variant(nil), variants...)
+	sort.SliceStable(byBandwidth, func(i, j int) bool {
+		return byBandwidth[i].Bandwidth > byBandwidth[j].Bandwidth
+	})
+
+	// только варианты с известным разрешением имеют смысл для NNNp
+	var sized []Variant
+	for _, v := range byBandwidth {
+		if v.Height > 0 {
+			sized = append(sized, v)
+		}
+	}
+
+	switch {
+	case q.Mode == QualityWorst:
+		return byBandwidth[len(byBandwidth)-1], nil
+	case q.Mode == QualityBest || len(sized) == 0:
+		return byBandwidth[0], nil
+	}
+
+	if q.Mode == QualityAtMost {
+		var best *Variant
+		for i := range sized {
+			v := &sized[i]
+			if v.Height > q.Height {
+				continue
+			}
+			if best == nil || v.Height > best.Height {
+				best = v
+			}
+		}
+		if best != nil {
+			return *best, nil
+		}
+		// всё выше лимита — отдаём самый маленький
+		lowest := sized[0]
+		for _, v := range sized[1:] {
+			if v.Height < lowest.Height {
+				lowest = v
+			}
+		}
+		return lowest, nil
+	}
+
+	// QualityExact: точное совпадение или ближайший (при равенстве — меньший)
+	best := sized[0]
+	bestDiff := absInt(best.Height - q.Height)
+	for _, v := range sized[1:] {
+		d := absInt(v.Height - q.Height)
+		if d < bestDiff || (d == bestDiff && v.Height < best.Height) {
+			best, bestDiff = v, d
+		}
+	}
+	return best, nil
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
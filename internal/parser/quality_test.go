@@ -0,0 +1,58 @@
+package parser
+
+import "testing"
+
+func TestSelectVariant(t *testing.T) {
+	variants := []Variant{
+		{URI: "480", Bandwidth: 1200000, Height: 480},
+		{URI: "1080", Bandwidth: 5000000, Height: 1080},
+		{URI: "360", Bandwidth: 800000, Height: 360},
+		{URI: "720", Bandwidth: 2500000, Height: 720},
+	}
+	tests := []struct {
+		quality string
+		want    string
+	}{
+		{"", "1080"},
+		{"best", "1080"},
+		{"worst", "360"},
+		{"360p", "360"},
+		{"720", "720"},
+		{"<=720p", "720"},
+		{"<=600p", "480"},
+		{"<=240p", "360"},
+		{"600p", "480"}, // 720 и 480 одинаково близко — берём меньший
+		{"650p", "720"},
+		{"2160p", "1080"},
+		{"144p", "360"},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuality(tt.quality)
+		if err != nil {
+			t.Errorf("ParseQuality(%q): %v", tt.quality, err)
+			continue
+		}
+		got, err := SelectVariant(variants, q)
+		if err != nil || got.URI != tt.want {
+			t.Errorf("SelectVariant(%q) = %q, %v; want %q", tt.quality, got.URI, err, tt.want)
+		}
+	}
+}
+
+func TestSelectVariantWithoutResolution(t *testing.T) {
+	variants := []Variant{{URI: "low", Bandwidth: 500000}, {URI: "high", Bandwidth: 3000000}}
+	if got, _ := SelectVariant(variants, Quality{Mode: QualityExact, Height: 360}); got.URI != "high" {
+		t.Errorf("SelectVariant(360p) without resolutions = %q; want the highest bandwidth", got.URI)
+	}
+	if _, err := SelectVariant(nil, Quality{}); err == nil {
+		t.Error("SelectVariant(nil) must fail")
+	}
+}
+
+func TestParseQualityRejects(t *testing.T) {
+	for _, s := range []string{"hd", "0p", "-720p", "<=p"} {
+		if _, err := ParseQuality(s); err == nil {
+			t.Errorf("ParseQuality(%q) must fail", s)
+		}
+	}
+}
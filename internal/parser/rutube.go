@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,7 +15,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,81 +35,210 @@ var (
 
 // playOptions — минимум, который нам нужен
 type playOptions struct {
-	Title         string `json:"title"`
+	Title        string `json:"title"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	PictureURL   string `json:"picture_url"`
+	PreviewURL   string `json:"preview_url"`
+	Author       struct {
+		Name string `json:"name"`
+	} `json:"author"`
 	VideoBalancer struct {
 		M3u8 string `json:"m3u8"`
 	} `json:"video_balancer"`
+	Captions []captionTrack `json:"captions"`
 }
 
 // ExtractMP4 качает ролик по ссылке и возвращает имя файла (без папки)
-func ExtractMP4(videoURL string) (string, error) {
-	id, err := extractID(videoURL)
+func ExtractMP4(ctx context.Context, videoURL string, o Options) (string, error) {
+	res, err := Download(ctx, videoURL, o, nil)
 	if err != nil {
 		return "", err
 	}
-	opts, err := fetchOptions(id)
+	return res.FileName, nil
+}
+
+// Result — что получилось после загрузки; имена файлов без папки downloads/
+type Result struct {
+	FileName  string
+	Subtitles []string // SRT и VTT рядом с видео
+	Poster    string   // обложка рядом с видео
+	Live      bool     // записан прямой эфир
+}
+
+// Files — все файлы результата
+func (r *Result) Files() []string {
+	files := append([]string{r.FileName}, r.Subtitles...)
+	if r.Poster != "" {
+		files = append(files, r.Poster)
+	}
+	return files
+}
+
+// Download качает ролик, а при o.Subtitles и o.Poster — субтитры и обложку. onProgress может быть nil.
+func Download(ctx context.Context, videoURL string, o Options, onProgress func(doneSec, totalSec float64)) (*Result, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	ref, err := ParseVideoURL(videoURL)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	id := ref.ID
+	opts, err := fetchOptions(ctx, ref)
+	if err != nil {
+		return nil, err
 	}
 	if opts.VideoBalancer.M3u8 == "" {
-		return "", errors.New("пустой m3u8 в playOptions")
+		return nil, errors.New("пустой m3u8 в playOptions")
 	}
 
-	variantURL, err := pickBestVariant(opts.VideoBalancer.M3u8)
+	variant, err := o.pickSource(ctx, opts.VideoBalancer.M3u8)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if err := o.checkCodecs(variant); err != nil {
+		return nil, err
+	}
+	variantURL := variant.URI
+
+	// Эфир (нет EXT-X-ENDLIST) пишем, пока не закончится или не остановят; процентов у него нет
+	live, err := isLive(ctx, variantURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Считаем длительность по media-плейлисту — нужна только для процентов
+	var totalDur float64
+	if onProgress != nil && !live {
+		if totalDur, err = totalDurationSeconds(ctx, variantURL); err != nil {
+			// не критично — просто не сможем показать проценты
+			totalDur = 0
+		}
 	}
 
 	// итоговый путь
-	fileName := sanitize(opts.Title) + ".mp4"
+	if live && o.Live.RequireLimit && o.Live.MaxDuration == 0 {
+		return nil, ErrLiveNoLimit
+	}
+	if live && o.Anim.Format != AnimNone {
+		return nil, errors.New("GIF из прямого эфира не сделать — дождитесь, пока запись появится на RuTube")
+	}
+	if live && !o.Clip.IsZero() {
+		log.Println("⚠️ Фрагмент для эфира не поддерживается — пишем эфир целиком")
+		o.Clip = Clip{}
+	}
+	fileName := o.fileName(id, opts, variant)
+	if live {
+		// записи одного эфира в разное время не должны перезаписывать друг друга
+		fileName = tagFileName(fileName, "live "+time.Now().Format("2006-01-02 15-04"))
+	}
 	outPath := filepath.Join("downloads", fileName)
 	if err := os.MkdirAll("downloads", 0o755); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// ffmpeg сам расшифрует (AES-128), склеит и справится с обрывами
-	if err := ffmpegMuxFromM3U8(variantURL, outPath); err != nil {
-		return "", err
+	if live {
+		log.Printf("🔴 %s — прямой эфир, начинаем запись", id)
+		err = recordLiveTo(ctx, variantURL, outPath, o.workDir(id), o.codecArgs(), o.Live)
+	} else {
+		err = mux(ctx, variantURL, outPath, o.workDir(id), o.codecArgs(), o.Clip, totalDur, onProgress)
 	}
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{FileName: fileName, Live: live}
 
-	// Опциональный автоснос (минуты) из окружения DOWNLOAD_TTL_MIN
-	if ttlMin := ttlFromEnv(); ttlMin > 0 {
-		go func(p string, minutes int) {
-			time.Sleep(time.Duration(minutes) * time.Minute)
-			_ = os.Remove(p)
-		}(outPath, ttlMin)
+	if o.Subtitles != SubtitlesNone {
+		subs := opts.subtitles()
+		if len(subs) == 0 {
+			log.Printf("💬 У ролика %s нет субтитров", id)
+		}
+		files := saveSubtitles(ctx, subs, outPath, o.Clip)
+		for _, f := range files {
+			res.Subtitles = append(res.Subtitles, filepath.Base(f.SRT), filepath.Base(f.VTT))
+		}
+		// звук и .ts со встраиванием отсеял Validate; проверка — на случай вызова мимо него
+		if o.Subtitles == SubtitlesEmbed && len(files) > 0 && o.Audio == AudioNone && subtitleCodec(outPath) != "" {
+			if err := embedSubtitles(ctx, outPath, files); err != nil {
+				log.Printf("⚠️ Не удалось встроить субтитры в %s: %v", fileName, err)
+			}
+		}
 	}
 
-	return fileName, nil
+	// обложка необязательна: без неё видео всё равно отдаём
+	if o.Poster != PosterNone {
+		posterPath, err := savePoster(ctx, opts.posterURL(), outPath)
+		if err != nil {
+			log.Printf("⚠️ Обложка %s: %v", id, err)
+		} else {
+			res.Poster = filepath.Base(posterPath)
+			if o.Poster == PosterEmbed && canEmbedPoster(outPath, posterPath) {
+				if err := embedPoster(ctx, outPath, posterPath); err != nil {
+					log.Printf("⚠️ Не удалось встроить обложку в %s: %v", fileName, err)
+				}
+			}
+		}
+	}
+	return res, nil
 }
 
 // --- helpers --------------------------------------------------------------
 
-func extractID(input string) (string, error) {
+// VideoRef — ролик, распознанный из ссылки
+type VideoRef struct {
+	ID         string
+	PrivateKey string // ?p=... у приватных ссылок
+}
+
+var (
+	reBareID = regexp.MustCompile(`(?i)^[a-f0-9]{32}$`)
+	// /video/<id>, /video/private/<id>, /shorts/<id>, /play/embed/<id>, /embed/<id>
+	reVideoPath = regexp.MustCompile(`(?i)^/(?:video/private|video|shorts|play/embed|embed)/([a-f0-9]{32})(?:/.*)?$`)
+)
+
+// ParseVideoURL распознаёт все публичные формы ссылок RuTube: обычные, мобильные,
+// shorts, embed и приватные (ключ ?p= сохраняется). Голый 32-символьный ID тоже подходит.
+func ParseVideoURL(input string) (VideoRef, error) {
 	input = strings.TrimSpace(input)
-	re := regexp.MustCompile(`(?i)^https?://rutube\.ru/video/([a-f0-9]{32})/?$`)
-	m := re.FindStringSubmatch(input)
+	if reBareID.MatchString(input) {
+		return VideoRef{ID: strings.ToLower(input)}, nil
+	}
+	u, err := parseRutubeURL(input)
+	if err != nil {
+		return VideoRef{}, err
+	}
+
+	m := reVideoPath.FindStringSubmatch(u.Path)
 	if len(m) < 2 {
-		return "", errors.New("не смог распознать ID видео")
+		return VideoRef{}, errors.New("не смог распознать ID видео")
 	}
-	return m[1], nil
+	return VideoRef{
+		ID:         strings.ToLower(m[1]),
+		PrivateKey: u.Query().Get("p"),
+	}, nil
 }
 
-func ttlFromEnv() int {
-	s := strings.TrimSpace(os.Getenv("DOWNLOAD_TTL_MIN"))
-	if s == "" {
-		return 0
+// parseRutubeURL разбирает ссылку на rutube.ru для видео, плейлистов и каналов:
+// схема необязательна, www. и m. отбрасываются, другие сайты — ошибка
+func parseRutubeURL(input string) (*url.URL, error) {
+	input = strings.TrimSpace(input)
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
 	}
-	n, err := strconv.Atoi(s)
-	if err != nil || n < 0 {
-		return 0
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, errors.New("не смог распознать ссылку")
 	}
-	return n
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."), "m.")
+	if host != "rutube.ru" {
+		return nil, errors.New("это не ссылка на RuTube")
+	}
+	return u, nil
 }
 
 // общий GET с нужными заголовками
-func httpGetWithHeaders(u string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", u, nil)
+func httpGetWithHeaders(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -124,44 +253,78 @@ func httpGetWithHeaders(u string) (*http.Response, error) {
 }
 
 // 1) init → 2) play/options → 3) HTML fallback
-func fetchOptions(id string) (*playOptions, error) {
+func fetchOptions(ctx context.Context, ref VideoRef) (*playOptions, error) {
+	private := false
+
 	// 1) init
-	if po, err := fetchOptionsInit(id); err == nil && po.VideoBalancer.M3u8 != "" {
+	if po, err := fetchOptionsInit(ctx, ref); err == nil && po.VideoBalancer.M3u8 != "" {
 		log.Println("✅ Использован init-эндпоинт")
 		return po, nil
 	} else if err != nil {
+		private = private || errors.Is(err, ErrPrivateVideo)
 		log.Printf("⚠️ init-эндпоинт не сработал: %v", err)
 	}
 
 	// 2) play/options
-	if po, err := fetchOptionsPlayOptions(id); err == nil && po.VideoBalancer.M3u8 != "" {
+	if po, err := fetchOptionsPlayOptions(ctx, ref); err == nil && po.VideoBalancer.M3u8 != "" {
 		log.Println("✅ Использован play/options")
 		return po, nil
 	} else if err != nil {
+		private = private || errors.Is(err, ErrPrivateVideo)
 		log.Printf("⚠️ play/options не сработал: %v", err)
 	}
 
 	// 3) HTML fallback
-	if po, err := fetchOptionsFromHTML(id); err == nil && po.VideoBalancer.M3u8 != "" {
+	if po, err := fetchOptionsFromHTML(ctx, ref); err == nil && po.VideoBalancer.M3u8 != "" {
 		log.Println("✅ Использован HTML-фолбэк (video_balancer.m3u8)")
 		return po, nil
 	} else if err != nil {
+		private = private || errors.Is(err, ErrPrivateVideo)
 		log.Printf("❌ HTML-фолбэк не сработал: %v", err)
 	}
 
+	if private {
+		return nil, ErrPrivateVideo
+	}
 	return nil, errors.New("не удалось получить m3u8 ни из init, ни из play/options, ни из HTML")
 }
 
-func fetchOptionsInit(id string) (*playOptions, error) {
-	u := fmt.Sprintf("https://rutube.ru/api/video/%s/init", id)
-	resp, err := httpGetWithHeaders(u)
+// ErrPrivateVideo — ролик приватный, а ключа ?p= в ссылке нет или он неверный
+var ErrPrivateVideo = errors.New("приватное видео: ключ доступа отсутствует или неверный")
+
+// httpStatusError — ошибка по ответу не-200; 401/403 или "private" в JSON-ответе API — это приватный ролик
+func httpStatusError(what string, resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	err := fmt.Errorf("%s http %d: %s", what, resp.StatusCode, strings.TrimSpace(string(b)))
+	isJSON := strings.Contains(resp.Header.Get("Content-Type"), "json")
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden ||
+		(isJSON && bytes.Contains(bytes.ToLower(b), []byte("private"))) {
+		return fmt.Errorf("%w (%v)", ErrPrivateVideo, err)
+	}
+	return err
+}
+
+// withPrivateKey добавляет ключ приватного ролика к URL
+func withPrivateKey(u string, ref VideoRef) string {
+	if ref.PrivateKey == "" {
+		return u
+	}
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	return u + sep + "p=" + url.QueryEscape(ref.PrivateKey)
+}
+
+func fetchOptionsInit(ctx context.Context, ref VideoRef) (*playOptions, error) {
+	u := withPrivateKey(fmt.Sprintf("https://rutube.ru/api/video/%s/init", ref.ID), ref)
+	resp, err := httpGetWithHeaders(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return nil, fmt.Errorf("init http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		return nil, httpStatusError("init", resp)
 	}
 
 	var full map[string]any
@@ -175,25 +338,25 @@ func fetchOptionsInit(id string) (*playOptions, error) {
 		return nil, errors.New("m3u8 не найден в init")
 	}
 	title, _ := full["title"].(string)
-
-	return &playOptions{
-		Title: title,
-		VideoBalancer: struct {
-			M3u8 string `json:"m3u8"`
-		}{M3u8: m3u8url},
-	}, nil
+	thumb, _ := full["thumbnail_url"].(string)
+	author, _ := full["author"].(map[string]any)
+	authorName, _ := author["name"].(string)
+
+	po := &playOptions{Title: title, ThumbnailURL: thumb}
+	po.VideoBalancer.M3u8 = m3u8url
+	po.Author.Name = authorName
+	return po, nil
 }
 
-func fetchOptionsPlayOptions(id string) (*playOptions, error) {
-	u := fmt.Sprintf("https://rutube.ru/api/play/options/%s/?no_404=true&referer=https%%3A%%2F%%2Frutube.ru", id)
-	resp, err := httpGetWithHeaders(u)
+func fetchOptionsPlayOptions(ctx context.Context, ref VideoRef) (*playOptions, error) {
+	u := withPrivateKey(fmt.Sprintf("https://rutube.ru/api/play/options/%s/?no_404=true&referer=https%%3A%%2F%%2Frutube.ru", ref.ID), ref)
+	resp, err := httpGetWithHeaders(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return nil, fmt.Errorf("play/options http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		return nil, httpStatusError("play/options", resp)
 	}
 	var po playOptions
 	if err := json.NewDecoder(resp.Body).Decode(&po); err != nil {
@@ -206,16 +369,18 @@ func fetchOptionsPlayOptions(id string) (*playOptions, error) {
 }
 
 // HTML fallback — вытаскиваем video_balancer.m3u8 из инлайнового JSON на странице
-func fetchOptionsFromHTML(id string) (*playOptions, error) {
-	pageURL := "https://rutube.ru/video/" + id + "/"
-	resp, err := httpGetWithHeaders(pageURL)
+func fetchOptionsFromHTML(ctx context.Context, ref VideoRef) (*playOptions, error) {
+	pageURL := "https://rutube.ru/video/" + ref.ID + "/"
+	if ref.PrivateKey != "" {
+		pageURL = withPrivateKey("https://rutube.ru/video/private/"+ref.ID+"/", ref)
+	}
+	resp, err := httpGetWithHeaders(ctx, pageURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return nil, fmt.Errorf("html http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		return nil, httpStatusError("html", resp)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -247,53 +412,34 @@ func fetchOptionsFromHTML(id string) (*playOptions, error) {
 		title = string(t[1])
 	}
 
-	return &playOptions{
-		Title: title,
-		VideoBalancer: struct {
-			M3u8 string `json:"m3u8"`
-		}{M3u8: m3u8url},
-	}, nil
+	// Превью — тоже по возможности
+	thumb := ""
+	reThumb := regexp.MustCompile(`"thumbnail_url"\s*:\s*"([^"]+)"`)
+	if t := reThumb.FindSubmatch(body); len(t) >= 2 {
+		thumb = strings.ReplaceAll(string(t[1]), `\u002F`, `/`)
+	}
+
+	po := &playOptions{Title: title, ThumbnailURL: thumb}
+	po.VideoBalancer.M3u8 = m3u8url
+	return po, nil
 }
 
-// pickBestVariant — если master, берём самый "жирный" вариант; если media — возвращаем как есть
-func pickBestVariant(m3u8url string) (string, error) {
-	resp, err := httpGetWithHeaders(m3u8url)
+// pickVariant — если master, выбираем вариант под запрошенное качество; если media — возвращаем как есть
+func pickVariant(ctx context.Context, m3u8url string, q Quality) (Variant, error) {
+	variants, err := ListVariants(ctx, m3u8url)
 	if err != nil {
-		return "", fmt.Errorf("ошибка загрузки m3u8: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return "", fmt.Errorf("m3u8 http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		return Variant{}, err
 	}
-
-	// читаем в буфер, чтобы можно было пробовать и master, и media
-	data, err := io.ReadAll(resp.Body)
+	v, err := SelectVariant(variants, q)
 	if err != nil {
-		return "", err
-	}
-
-	// сначала пробуем как master
-	if mpl, err := tryDecodeMaster(data); err == nil && len(mpl.Variants) > 0 {
-		sort.Slice(mpl.Variants, func(i, j int) bool {
-			return mpl.Variants[i].Bandwidth > mpl.Variants[j].Bandwidth
-		})
-		best := mpl.Variants[0].URI
-		return resolveURL(m3u8url, best), nil
+		return Variant{}, err
 	}
-
-	// возможно, это media — ок, вернём исходный URL
-	if _, err := tryDecodeMedia(data); err == nil {
+	if v.Height > 0 {
+		log.Printf("🎞️ Выбран вариант %s (%s, %d bps) по запросу %q", v.Label(), v.Resolution, v.Bandwidth, q.String())
+	} else if len(variants) == 1 && v.URI == m3u8url {
 		log.Println("⚠️ M3U8 не содержит вариантов, используем напрямую как media")
-		return m3u8url, nil
-	}
-
-	// ни master, ни media — странно, вернём кусок плейлиста для отладки
-	sample := string(data)
-	if len(sample) > 200 {
-		sample = sample[:200]
 	}
-	return "", fmt.Errorf("не удалось распарсить плейлист (ни master, ни media). фрагмент: %q", sample)
+	return v, nil
 }
 
 func tryDecodeMaster(b []byte) (*m3u8.MasterPlaylist, error) {
@@ -313,13 +459,10 @@ func tryDecodeMedia(b []byte) (*m3u8.MediaPlaylist, error) {
 	return pl.(*m3u8.MediaPlaylist), nil
 }
 
-func ffmpegMuxFromM3U8(m3u8url, outPath string) error {
-	ffmpegPath := "ffmpeg"
-	if runtime.GOOS == "windows" {
-		ffmpegPath = "ffmpeg/bin/ffmpeg.exe"
-	}
-	if _, err := exec.LookPath(ffmpegPath); err != nil && runtime.GOOS != "windows" {
-		return fmt.Errorf("ffmpeg не найден в PATH: %w", err)
+func ffmpegMuxFromM3U8(ctx context.Context, m3u8url, outPath string, codec []string) error {
+	ffmpegPath, err := ffmpegBinary()
+	if err != nil {
+		return err
 	}
 
 	args := []string{
@@ -341,20 +484,30 @@ func ffmpegMuxFromM3U8(m3u8url, outPath string) error {
 
 		// вход
 		"-i", m3u8url,
-
-		// без перекодирования
-		"-c", "copy",
-
-		outPath,
 	}
+	// без перекодирования или только звук
+	args = append(args, codec...)
+	args = append(args, outPath)
 
-	cmd := exec.Command(ffmpegPath, args...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
 	// Хотите отладку в логи сервера — можно склеить вывод в буфер и вернуть в ошибке.
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
+// ffmpegBinary — путь к ffmpeg (на Windows — рядом с бинарником)
+func ffmpegBinary() (string, error) {
+	ffmpegPath := "ffmpeg"
+	if runtime.GOOS == "windows" {
+		ffmpegPath = "ffmpeg/bin/ffmpeg.exe"
+	}
+	if _, err := exec.LookPath(ffmpegPath); err != nil && runtime.GOOS != "windows" {
+		return "", fmt.Errorf("ffmpeg не найден в PATH: %w", err)
+	}
+	return ffmpegPath, nil
+}
+
 func resolveURL(master, ref string) string {
 	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
 		return ref
@@ -386,42 +539,17 @@ func sanitize(s string) string {
 }
 
 // ExtractMP4WithProgress — то же, что ExtractMP4, но коллбеком репортит прогресс (секунды из ffmpeg / общая длительность).
-func ExtractMP4WithProgress(videoURL string, onProgress func(doneSec, totalSec float64)) (string, error) {
-	id, err := extractID(videoURL)
-	if err != nil {
-		return "", err
-	}
-	opts, err := fetchOptions(id)
+func ExtractMP4WithProgress(ctx context.Context, videoURL string, o Options, onProgress func(doneSec, totalSec float64)) (string, error) {
+	res, err := Download(ctx, videoURL, o, onProgress)
 	if err != nil {
 		return "", err
 	}
-	variantURL, err := pickBestVariant(opts.VideoBalancer.M3u8)
-	if err != nil {
-		return "", err
-	}
-
-	// Считаем длительность по media-плейлисту
-	totalDur, err := totalDurationSeconds(variantURL)
-	if err != nil {
-		// не критично — просто не сможем показать проценты
-		totalDur = 0
-	}
-
-	fileName := sanitize(opts.Title) + ".mp4"
-	outPath := filepath.Join("downloads", fileName)
-	if err := os.MkdirAll("downloads", 0o755); err != nil {
-		return "", err
-	}
-
-	if err := ffmpegMuxFromM3U8WithProgress(variantURL, outPath, totalDur, onProgress); err != nil {
-		return "", err
-	}
-	return fileName, nil
+	return res.FileName, nil
 }
 
 // totalDurationSeconds скачивает media m3u8 и суммирует EXTINF
-func totalDurationSeconds(m3u8url string) (float64, error) {
-	resp, err := httpGetWithHeaders(m3u8url)
+func totalDurationSeconds(ctx context.Context, m3u8url string) (float64, error) {
+	resp, err := httpGetWithHeaders(ctx, m3u8url)
 	if err != nil {
 		return 0, err
 	}
@@ -437,7 +565,7 @@ func totalDurationSeconds(m3u8url string) (float64, error) {
 		// если вдруг мастер — возьмём лучший и повторим
 		if mp, ok := parsed.(*m3u8.MasterPlaylist); ok && len(mp.Variants) > 0 {
 			best := mp.Variants[0].URI
-			return totalDurationSeconds(resolveURL(m3u8url, best))
+			return totalDurationSeconds(ctx, resolveURL(m3u8url, best))
 		}
 		return 0, fmt.Errorf("ожидался media playlist")
 	}
@@ -451,13 +579,10 @@ func totalDurationSeconds(m3u8url string) (float64, error) {
 	return sum, nil
 }
 
-func ffmpegMuxFromM3U8WithProgress(m3u8url, outPath string, totalDur float64, onProgress func(done, total float64)) error {
-	ffmpegPath := "ffmpeg"
-	if runtime.GOOS == "windows" {
-		ffmpegPath = "ffmpeg/bin/ffmpeg.exe"
-	}
-	if _, err := exec.LookPath(ffmpegPath); err != nil && runtime.GOOS != "windows" {
-		return fmt.Errorf("ffmpeg не найден в PATH: %w", err)
+func ffmpegMuxFromM3U8WithProgress(ctx context.Context, m3u8url, outPath string, codec []string, totalDur float64, onProgress func(done, total float64)) error {
+	ffmpegPath, err := ffmpegBinary()
+	if err != nil {
+		return err
 	}
 
 	args := []string{
@@ -465,15 +590,19 @@ func ffmpegMuxFromM3U8WithProgress(m3u8url, outPath string, totalDur float64, on
 		"-protocol_whitelist", "file,http,https,tcp,tls,crypto",
 		"-user_agent", defaultUA,
 		"-referer", defaultRef,
-		// прогресс в stdout раз в 1с
-		"-stats_period", "1",
-		"-progress", "pipe:1",
 		"-i", m3u8url,
-		"-c", "copy",
-		outPath,
 	}
+	args = append(args, codec...)
+	args = append(args, outPath)
+	return runFFmpegProgress(ctx, ffmpegPath, args, totalDur, onProgress)
+}
+
+// runFFmpegProgress запускает ffmpeg и репортит секунды результата из -progress (totalDur — для 100%)
+func runFFmpegProgress(ctx context.Context, ffmpegPath string, args []string, totalDur float64, onProgress func(done, total float64)) error {
+	// прогресс в stdout раз в 1с
+	args = append([]string{"-stats_period", "1", "-progress", "pipe:1"}, args...)
 
-	cmd := exec.Command(ffmpegPath, args...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
 	stdout, _ := cmd.StdoutPipe()
 	cmd.Stderr = os.Stderr
 
@@ -0,0 +1,36 @@
+package parser
+
+import "testing"
+
+func TestParseVideoURL(t *testing.T) {
+	const id = "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		in      string
+		want    VideoRef
+		wantErr bool
+	}{
+		{"https://rutube.ru/video/" + id + "/", VideoRef{ID: id}, false},
+		{"rutube.ru/video/" + id, VideoRef{ID: id}, false},
+		{"  https://www.rutube.ru/video/" + id + "/?r=wd  ", VideoRef{ID: id}, false},
+		{"https://m.rutube.ru/video/" + id + "/", VideoRef{ID: id}, false},
+		{"https://rutube.ru/video/0123456789ABCDEF0123456789ABCDEF/", VideoRef{ID: id}, false},
+		{"https://rutube.ru/shorts/" + id + "/", VideoRef{ID: id}, false},
+		{"https://rutube.ru/play/embed/" + id, VideoRef{ID: id}, false},
+		{"https://rutube.ru/embed/" + id, VideoRef{ID: id}, false},
+		{"https://rutube.ru/video/private/" + id + "/?p=abc123", VideoRef{ID: id, PrivateKey: "abc123"}, false},
+		{"https://rutube.ru/video/" + id + "/?p=abc123", VideoRef{ID: id, PrivateKey: "abc123"}, false},
+		{id, VideoRef{ID: id}, false},
+		{"", VideoRef{}, true},
+		{"https://youtube.com/video/" + id + "/", VideoRef{}, true},
+		{"https://rutube.ru.evil.com/video/" + id + "/", VideoRef{}, true},
+		{"https://rutube.ru/video/12345/", VideoRef{}, true},
+		{"https://rutube.ru/channel/123/", VideoRef{}, true},
+		{"https://rutube.ru/plst/123/", VideoRef{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVideoURL(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseVideoURL(%q) = %+v, %v; want %+v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
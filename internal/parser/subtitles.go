@@ -0,0 +1,250 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubtitleMode — что делать с субтитрами ролика
+type SubtitleMode string
+
+const (
+	SubtitlesNone  SubtitleMode = ""      // не качать
+	SubtitlesFiles SubtitleMode = "files" // SRT и VTT рядом с видео
+	SubtitlesEmbed SubtitleMode = "embed" // файлы + мягкая дорожка внутри MP4
+)
+
+// ParseSubtitleMode разбирает режим из формы; пусто и "none" — без субтитров
+func ParseSubtitleMode(s string) (SubtitleMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none":
+		return SubtitlesNone, nil
+	case "files":
+		return SubtitlesFiles, nil
+	case "embed":
+		return SubtitlesEmbed, nil
+	}
+	return SubtitlesNone, fmt.Errorf("неизвестный режим субтитров %q", s)
+}
+
+// captionTrack — элемент captions из play/options
+type captionTrack struct {
+	Code  string `json:"code"`
+	Title string `json:"langTitle"`
+	File  string `json:"file"`
+	URL   string `json:"url"` // встречается вместо file
+}
+
+// Subtitle — дорожка субтитров ролика
+type Subtitle struct {
+	Lang  string `json:"lang"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// subtitles — дорожки из play/options с абсолютными ссылками
+func (po *playOptions) subtitles() []Subtitle {
+	var out []Subtitle
+	for i, c := range po.Captions {
+		u := c.File
+		if u == "" {
+			u = c.URL
+		}
+		if u == "" {
+			continue
+		}
+		lang := strings.ToLower(strings.TrimSpace(c.Code))
+		if lang == "" {
+			lang = "sub" + strconv.Itoa(i+1)
+		}
+		out = append(out, Subtitle{Lang: lang, Title: c.Title, URL: resolveURL(defaultRef, u)})
+	}
+	return out
+}
+
+// subtitleFile — скачанная дорожка: пути к SRT и VTT
+type subtitleFile struct {
+	Lang string
+	SRT  string
+	VTT  string
+}
+
+// saveSubtitles качает дорожки и сохраняет каждую в SRT и VTT рядом с видео.
+// Для фрагмента остаются только его реплики со временем от начала фрагмента.
+// Сбой одной дорожки не мешает остальным и самому видео.
+func saveSubtitles(ctx context.Context, subs []Subtitle, videoPath string, clip Clip) []subtitleFile {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	var out []subtitleFile
+	for _, s := range subs {
+		data, err := fetchBytes(ctx, s.URL, 0, 0)
+		if err != nil {
+			log.Printf("⚠️ Субтитры %s: %v", s.Lang, err)
+			continue
+		}
+		cues := clipCues(parseCues(data), clip)
+		if len(cues) == 0 {
+			log.Printf("⚠️ Субтитры %s: не нашлось ни одной реплики", s.Lang)
+			continue
+		}
+		f := subtitleFile{
+			Lang: s.Lang,
+			SRT:  base + "." + sanitizeFileName(s.Lang) + ".srt",
+			VTT:  base + "." + sanitizeFileName(s.Lang) + ".vtt",
+		}
+		if err := os.WriteFile(f.SRT, formatSRT(cues), 0o644); err != nil {
+			log.Printf("⚠️ Субтитры %s: %v", s.Lang, err)
+			continue
+		}
+		if err := os.WriteFile(f.VTT, formatVTT(cues), 0o644); err != nil {
+			log.Printf("⚠️ Субтитры %s: %v", s.Lang, err)
+			_ = os.Remove(f.SRT)
+			continue
+		}
+		log.Printf("💬 Субтитры %s: %d реплик", s.Lang, len(cues))
+		out = append(out, f)
+	}
+	return out
+}
+
+// embedSubtitles добавляет дорожки как мягкие субтитры в кодеке контейнера, без перекодирования видео
+func embedSubtitles(ctx context.Context, videoPath string, subs []subtitleFile) error {
+	ffmpegPath, err := ffmpegBinary()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(videoPath)
+	tmp := strings.TrimSuffix(videoPath, ext) + ".part" + ext
+
+	args := []string{"-y", "-i", videoPath}
+	for _, s := range subs {
+		args = append(args, "-i", s.SRT)
+	}
+	args = append(args, "-map", "0")
+	for i := range subs {
+		args = append(args, "-map", strconv.Itoa(i+1))
+	}
+	args = append(args, "-c", "copy", "-c:s", subtitleCodec(videoPath))
+	for i, s := range subs {
+		args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+s.Lang)
+	}
+	args = append(args, tmp)
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, videoPath)
+}
+
+// --- разбор и запись SRT/WebVTT ---------------------------------------------
+
+// cue — одна реплика
+type cue struct {
+	Start, End time.Duration
+	Text       string
+}
+
+// "00:01:02,500 --> 00:01:04.000 align:start" — SRT через запятую, VTT через точку, часы в VTT необязательны
+var reCueTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+
+// parseCues понимает и SRT, и WebVTT: блоки без строки таймингов (номера, WEBVTT, NOTE, STYLE) пропускаются
+func parseCues(data []byte) []cue {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	var out []cue
+	var cur *cue
+	var text []string
+	flush := func() {
+		if cur != nil && len(text) > 0 {
+			cur.Text = strings.Join(text, "\n")
+			out = append(out, *cur)
+		}
+		cur, text = nil, nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" {
+			flush()
+			continue
+		}
+		if m := reCueTiming.FindStringSubmatch(line); m != nil {
+			flush()
+			start, err1 := parseCueTime(m[1])
+			end, err2 := parseCueTime(m[2])
+			if err1 == nil && err2 == nil {
+				cur = &cue{Start: start, End: end}
+			}
+			continue
+		}
+		if cur != nil {
+			text = append(text, line)
+		}
+	}
+	flush()
+	return out
+}
+
+// clipCues оставляет реплики, попадающие в [Start, End), и сдвигает их на -Start;
+// реплики на границе обрезаются по ней
+func clipCues(cues []cue, c Clip) []cue {
+	if c.IsZero() {
+		return cues
+	}
+	var out []cue
+	for _, q := range cues {
+		if q.End <= c.Start || (c.End > 0 && q.Start >= c.End) {
+			continue
+		}
+		if c.End > 0 && q.End > c.End {
+			q.End = c.End
+		}
+		q.Start = max(q.Start-c.Start, 0)
+		q.End -= c.Start
+		out = append(out, q)
+	}
+	return out
+}
+
+func parseCueTime(s string) (time.Duration, error) {
+	return ParseTimestamp(strings.Replace(s, ",", ".", 1))
+}
+
+func formatSRT(cues []cue) []byte {
+	var b bytes.Buffer
+	for i, c := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, cueTime(c.Start, ','), cueTime(c.End, ','), c.Text)
+	}
+	return b.Bytes()
+}
+
+func formatVTT(cues []cue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", cueTime(c.Start, '.'), cueTime(c.End, '.'), c.Text)
+	}
+	return b.Bytes()
+}
+
+// cueTime — чч:мм:сс,ммм (SRT) или чч:мм:сс.ммм (VTT)
+func cueTime(d time.Duration, sep byte) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}